@@ -5,6 +5,7 @@ import (
 	"bufio"
 	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"os/exec"
 )
@@ -12,10 +13,13 @@ import (
 //go:embed js/djot.js
 var djotJs string
 
+//go:embed js/mathml.js
+var mathmlJs string
+
 //go:embed js/main.js
 var mainJs string
 
-var djotFullScript = djotJs + "\n" + mainJs
+var djotFullScript = djotJs + "\n" + mathmlJs + "\n" + mainJs
 
 const delimiter = 0xff
 
@@ -42,6 +46,9 @@ func StartService() {
 	writer := bufio.NewWriter(stdin)
 	scanner := bufio.NewScanner(stdout)
 	scanner.Split(splitAtDelimiter)
+	// Responses contain whole html pages, which can be much bigger than
+	// the default 64KiB limit.
+	scanner.Buffer(nil, 64*1024*1024)
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
@@ -71,9 +78,99 @@ func splitAtDelimiter(data []byte, atEOF bool) (advance int, token []byte, err e
 	return idx + 1, data[:idx], nil
 }
 
+// Context needed to render a djot file as part of a website.
+type Options struct {
+	// Path of the file being rendered, relative to the website's folder.
+	Path string `json:"path"`
+
+	// Maps the path of every djot file in the website to its generated
+	// page, so that links to .dj files can be rewritten.
+	Xrefs map[string]Xref `json:"xrefs"`
+
+	// User-defined filters, applied in order before any built-in transform.
+	Filters []Filter `json:"filters"`
+
+	// Self links added to section headings. Nil means no links.
+	HeadingLinks *HeadingLinks `json:"headingLinks"`
+}
+
+type HeadingLinks struct {
+	// Link content, which may contain html markup.
+	Symbol string `json:"symbol"`
+	// Whether the link goes before the heading's text instead of after.
+	Before bool   `json:"before"`
+	Class  string `json:"class"`
+	// Heading levels (1-6) that get links.
+	Levels []int `json:"levels"`
+}
+
+// A djot filter script, in the same format accepted by djot's CLI: its body
+// returns a filter object (or an array of them), with djot in scope.
+type Filter struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+type Xref struct {
+	Url   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// A heading, along with its subheadings.
+type TOCEntry struct {
+	Level    int        `json:"level"`
+	Text     string     `json:"text"`
+	Id       string     `json:"id"`
+	Children []TOCEntry `json:"children"`
+}
+
+// A problem found in the djot input.
+type Error struct {
+	Msg string `json:"msg"`
+	// Line number relative to the input. Zero value means unavailable.
+	Line int `json:"line"`
+	// Name of the filter that caused this error, if any. In that case Line
+	// is relative to the filter's source instead.
+	Filter string `json:"filter"`
+}
+
+// A shortcode found in the djot input. Its content is left in the
+// generated html between these markers, where N is the shortcode's index:
+//
+//	<!--s4g-shortcode:N-->content<!--/s4g-shortcode:N-->
+//
+// Nested shortcodes always have lower indices than their parents.
+type Shortcode struct {
+	Name string            `json:"name"`
+	Args map[string]string `json:"args"`
+	// Line number relative to the input. Zero value means unavailable.
+	Line int `json:"line"`
+}
+
+type Result struct {
+	Html       string      `json:"html"`
+	TOC        []TOCEntry  `json:"toc"`
+	Shortcodes []Shortcode `json:"shortcodes"`
+}
+
+type request struct {
+	Input string `json:"input"`
+	Options
+}
+
+type response struct {
+	Result
+	Errors []Error `json:"errors"`
+}
+
 // Not thread-safe.
-func ToHtml(input []byte) (result []byte) {
-	if _, err := service.writer.Write(input); err != nil {
+func ToHtml(input []byte, opts Options) (result Result, problems []Error) {
+	req, err := json.Marshal(request{Input: string(input), Options: opts})
+	if err != nil {
+		panic(err)
+	}
+
+	if _, err := service.writer.Write(req); err != nil {
 		panic(err)
 	}
 	if err := service.writer.WriteByte(delimiter); err != nil {
@@ -84,8 +181,14 @@ func ToHtml(input []byte) (result []byte) {
 	if !service.scanner.Scan() {
 		panic(fmt.Sprintf(
 			"scanner unexpectedly stopped while converting djot to html: %s\n",
-			input[:50],
+			input[:min(len(input), 50)],
 		))
 	}
-	return service.scanner.Bytes()
+
+	var resp response
+	err = json.Unmarshal(service.scanner.Bytes(), &resp)
+	if err != nil {
+		panic(err)
+	}
+	return resp.Result, resp.Errors
 }
@@ -0,0 +1,379 @@
+package djot
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if _, err := exec.LookPath("node"); err != nil {
+		fmt.Println("node not found, skipping djot tests")
+		os.Exit(0)
+	}
+	StartService()
+	os.Exit(m.Run())
+}
+
+func TestMath(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			"$`x^2`",
+			`<math><semantics><msup><mi>x</mi><mn>2</mn></msup>` +
+				`<annotation encoding="application/x-tex">x^2</annotation></semantics></math>`,
+		},
+		{
+			"$$`\\frac{a}{b}`",
+			`<math display="block"><semantics><mfrac><mi>a</mi><mi>b</mi></mfrac>`,
+		},
+		{
+			"$`\\alpha + \\sqrt{x_1}`",
+			`<mrow><mi>α</mi><mo>+</mo><msqrt><msub><mi>x</mi><mn>1</mn></msub></msqrt></mrow>`,
+		},
+		{
+			"$`\\sum_{i=1}^n i`",
+			`<msubsup><mo largeop="true">∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></msubsup>`,
+		},
+		{
+			"$`\\begin{pmatrix} a & b \\\\ c & d \\end{pmatrix}`",
+			`<mtable><mtr><mtd><mi>a</mi></mtd><mtd><mi>b</mi></mtd></mtr>` +
+				`<mtr><mtd><mi>c</mi></mtd><mtd><mi>d</mi></mtd></mtr></mtable>`,
+		},
+		{
+			"$`a < b`",
+			`<mi>a</mi><mo>&lt;</mo><mi>b</mi>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, problems := ToHtml([]byte(tt.input), Options{})
+			if len(problems) > 0 {
+				t.Fatalf("unexpected problems: %+v", problems)
+			}
+			if !strings.Contains(result.Html, tt.want) {
+				t.Errorf("html:\n%s\nshould contain:\n%s", result.Html, tt.want)
+			}
+		})
+	}
+}
+
+func TestMathErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantMsg string
+		line    int
+	}{
+		{"$`\\frac{a}`", `Missing argument for "\frac"`, 1},
+		{"$`\\unknowncmd`", `Unsupported command "\unknowncmd"`, 1},
+		{"$`a & b`", `"&" is only allowed inside an environment`, 1},
+		{"text\n\n$`{`", `Expected "}" but found end of math`, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, problems := ToHtml([]byte(tt.input), Options{})
+			if len(problems) != 1 {
+				t.Fatalf("expected 1 problem, got %+v", problems)
+			}
+			if !strings.Contains(problems[0].Msg, tt.wantMsg) || problems[0].Line != tt.line {
+				t.Errorf("got %+v, want line %d %q", problems[0], tt.line, tt.wantMsg)
+			}
+			// Invalid math is left as-is instead of breaking the page
+			if !strings.Contains(result.Html, `<span class="math inline">`) {
+				t.Errorf("unexpected html: %s", result.Html)
+			}
+		})
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	input := "# Title [with a note]{.shortcode name=note}\n" +
+		"\n" +
+		"{.shortcode .wide name=figure src=\"cat.jpg\"}\n" +
+		":::\n" +
+		"Hello [world]{.shortcode name=em}\n" +
+		":::\n"
+	result, problems := ToHtml([]byte(input), Options{})
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	want := []Shortcode{
+		{Name: "note", Args: map[string]string{}, Line: 1},
+		{Name: "em", Args: map[string]string{}, Line: 5},
+		{Name: "figure", Args: map[string]string{"src": "cat.jpg", "class": "wide"}, Line: 4},
+	}
+	if fmt.Sprint(result.Shortcodes) != fmt.Sprint(want) {
+		t.Errorf("shortcodes = %+v, want %+v", result.Shortcodes, want)
+	}
+
+	for _, marker := range []string{
+		"<!--s4g-shortcode:0-->with a note<!--/s4g-shortcode:0-->",
+		"<!--s4g-shortcode:1-->world<!--/s4g-shortcode:1-->",
+		"<!--s4g-shortcode:2-->",
+		"<!--/s4g-shortcode:2-->",
+	} {
+		if !strings.Contains(result.Html, marker) {
+			t.Errorf("html should contain %s:\n%s", marker, result.Html)
+		}
+	}
+
+	// Markers are html, not part of the heading's text
+	if len(result.TOC) != 1 || result.TOC[0].Text != "Title with a note" {
+		t.Errorf("toc = %+v", result.TOC)
+	}
+}
+
+func TestShortcodeWithoutName(t *testing.T) {
+	_, problems := ToHtml([]byte("text\n\n[oops]{.shortcode}\n"), Options{})
+	if len(problems) != 1 || problems[0].Line != 3 ||
+		problems[0].Msg != `Shortcode without a "name" attribute` {
+		t.Errorf("got %+v", problems)
+	}
+}
+
+func TestCrossReferences(t *testing.T) {
+	opts := Options{
+		Path: "posts/a.dj",
+		Xrefs: map[string]Xref{
+			"posts/b.dj":       {Url: "/blog/posts/b.html", Title: "Post B"},
+			"about.dj":         {Url: "/blog/about/", Title: "About"},
+			"posts/my file.dj": {Url: "/blog/posts/my%20file.html", Title: "Mine"},
+		},
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"[x](b.dj)", `<a href="/blog/posts/b.html">x</a>`},
+		{"[x](../about.dj)", `<a href="/blog/about/">x</a>`},
+		{"[x](/about.dj#team)", `<a href="/blog/about/#team">x</a>`},
+		{"[x](my%20file.dj)", `<a href="/blog/posts/my%20file.html">x</a>`},
+		{"[](b.dj)", `<a href="/blog/posts/b.html">Post B</a>`},
+		{
+			"[x][ref] and [y][ref]\n\n[ref]: b.dj\n",
+			`<a href="/blog/posts/b.html">x</a> and <a href="/blog/posts/b.html">y</a>`,
+		},
+		{"[x](https://example.com/a.dj)", `<a href="https://example.com/a.dj">x</a>`},
+		{"[x](notes.txt)", `<a href="notes.txt">x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, problems := ToHtml([]byte(tt.input), opts)
+			if len(problems) > 0 {
+				t.Fatalf("unexpected problems: %+v", problems)
+			}
+			if !strings.Contains(result.Html, tt.want) {
+				t.Errorf("html:\n%s\nshould contain:\n%s", result.Html, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrossReferenceErrors(t *testing.T) {
+	opts := Options{Path: "posts/a.dj", Xrefs: map[string]Xref{}}
+	tests := []struct {
+		input   string
+		wantMsg string
+	}{
+		{"text\n\n[x](c.dj)", `Link to unknown file "c.dj" (resolved to "posts/c.dj")`},
+		{"text\n\n[x](a%zz.dj)", `Invalid link "a%zz.dj": malformed escape sequence`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			_, problems := ToHtml([]byte(tt.input), opts)
+			if len(problems) != 1 || problems[0].Msg != tt.wantMsg || problems[0].Line != 3 {
+				t.Errorf("got %+v, want line 3 %q", problems, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestServiceSurvivesBadInput(t *testing.T) {
+	// Breaks the AST in a way that throws outside of the filter itself
+	filter := Filter{
+		Name:   "breaker.js",
+		Source: "return { para: (e) => { e.children = null; } }",
+	}
+	_, problems := ToHtml([]byte("hi"), Options{Filters: []Filter{filter}})
+	if len(problems) != 1 || !strings.HasPrefix(problems[0].Msg, "Failed to render djot") {
+		t.Errorf("got %+v", problems)
+	}
+
+	result, problems := ToHtml([]byte("still alive"), Options{})
+	if len(problems) > 0 || result.Html != "<p>still alive</p>\n" {
+		t.Errorf("got %q, %+v", result.Html, problems)
+	}
+}
+
+func TestFilterOrder(t *testing.T) {
+	appendText := func(name, suffix string) Filter {
+		return Filter{
+			Name:   name,
+			Source: `return { str: (e) => { e.text += "` + suffix + `"; } }`,
+		}
+	}
+	tests := []struct {
+		filters []Filter
+		want    string
+	}{
+		{nil, "<p>hi</p>\n"},
+		{[]Filter{appendText("a.js", "1"), appendText("b.js", "2")}, "<p>hi12</p>\n"},
+		{[]Filter{appendText("b.js", "2"), appendText("a.js", "1")}, "<p>hi21</p>\n"},
+		{
+			// A filter may also return a list of filters, applied in order
+			[]Filter{{
+				Name: "multi.js",
+				Source: `return [
+					{ str: (e) => { e.text += "x"; } },
+					{ str: (e) => { e.text = e.text.toUpperCase(); } },
+				]`,
+			}},
+			"<p>HIX</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		result, problems := ToHtml([]byte("hi"), Options{Filters: tt.filters})
+		if len(problems) > 0 {
+			t.Errorf("%v: unexpected problems: %+v", tt.filters, problems)
+			continue
+		}
+		if result.Html != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.filters, result.Html, tt.want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		wantMsg  string
+		wantLine int
+	}{
+		{
+			"// setup\n\nthrow new Error(\"boom\");",
+			"Error: boom", 3,
+		},
+		{
+			// Thrown while visiting nodes rather than when building the filter
+			"return {\n  str: (e) => {\n    throw new Error(\"bad \" + e.text);\n  }\n}",
+			"Error: bad text", 3,
+		},
+	}
+	ok := Filter{Name: "ok.js", Source: "return {}"}
+	for _, tt := range tests {
+		bad := Filter{Name: "bad.js", Source: tt.source}
+		_, problems := ToHtml([]byte("text\n\nmore"), Options{Filters: []Filter{ok, bad}})
+		if len(problems) != 1 {
+			t.Errorf("%q: got %+v, want 1 problem", tt.source, problems)
+			continue
+		}
+		p := problems[0]
+		if p.Filter != "bad.js" || p.Line != tt.wantLine || p.Msg != tt.wantMsg {
+			t.Errorf(
+				"%q: got %s:%d %q, want bad.js:%d %q",
+				tt.source, p.Filter, p.Line, p.Msg, tt.wantLine, tt.wantMsg,
+			)
+		}
+	}
+}
+
+func TestHeadingLinks(t *testing.T) {
+	input := "# One\n\n## Two\n\n### Three\n"
+	tests := []struct {
+		name  string
+		links *HeadingLinks
+		want  []string
+	}{
+		{
+			"disabled", nil,
+			[]string{"<h1>One</h1>", "<h2>Two</h2>", "<h3>Three</h3>"},
+		},
+		{
+			"after", &HeadingLinks{Symbol: "#", Levels: []int{1, 2, 3}},
+			[]string{
+				`<h1>One<a href="#One">#</a></h1>`,
+				`<h2>Two<a href="#Two">#</a></h2>`,
+				`<h3>Three<a href="#Three">#</a></h3>`,
+			},
+		},
+		{
+			"before, with class and html symbol",
+			&HeadingLinks{Symbol: "<i>§</i>", Before: true, Class: "hl", Levels: []int{1, 2, 3}},
+			[]string{
+				`<h1><a href="#One" class="hl"><i>§</i></a>One</h1>`,
+				`<h2><a href="#Two" class="hl"><i>§</i></a>Two</h2>`,
+				`<h3><a href="#Three" class="hl"><i>§</i></a>Three</h3>`,
+			},
+		},
+		{
+			"some levels", &HeadingLinks{Symbol: "#", Levels: []int{2, 3}},
+			[]string{
+				"<h1>One</h1>",
+				`<h2>Two<a href="#Two">#</a></h2>`,
+				`<h3>Three<a href="#Three">#</a></h3>`,
+			},
+		},
+		{
+			"no levels", &HeadingLinks{Symbol: "#", Levels: []int{}},
+			[]string{"<h1>One</h1>", "<h2>Two</h2>", "<h3>Three</h3>"},
+		},
+	}
+	for _, tt := range tests {
+		result, problems := ToHtml([]byte(input), Options{HeadingLinks: tt.links})
+		if len(problems) > 0 {
+			t.Errorf("%s: unexpected problems: %+v", tt.name, problems)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(result.Html, want) {
+				t.Errorf("%s: html:\n%s\nshould contain:\n%s", tt.name, result.Html, want)
+			}
+		}
+	}
+}
+
+func TestTOC(t *testing.T) {
+	input := "# One\n\n## Two _a_ `b`\n\n### Three\n\n## Four\n\n#### Deep\n\n# Five\n"
+	leaf := func(level int, text, id string) TOCEntry {
+		return TOCEntry{Level: level, Text: text, Id: id, Children: []TOCEntry{}}
+	}
+	want := []TOCEntry{
+		{
+			Level: 1, Text: "One", Id: "One",
+			Children: []TOCEntry{
+				{
+					Level: 2, Text: "Two a b", Id: "Two-a-b",
+					Children: []TOCEntry{leaf(3, "Three", "Three")},
+				},
+				{
+					// Skipped levels still nest under the closest heading
+					Level: 2, Text: "Four", Id: "Four",
+					Children: []TOCEntry{leaf(4, "Deep", "Deep")},
+				},
+			},
+		},
+		leaf(1, "Five", "Five"),
+	}
+
+	// Heading links' text must not end up in the TOC, whichever side
+	// they're on.
+	for _, links := range []*HeadingLinks{
+		nil,
+		{Symbol: "#", Levels: []int{1, 2, 3, 4}},
+		{Symbol: "<b>§</b>", Before: true, Levels: []int{1, 2, 3, 4}},
+	} {
+		result, problems := ToHtml([]byte(input), Options{HeadingLinks: links})
+		if len(problems) > 0 {
+			t.Fatalf("unexpected problems: %+v", problems)
+		}
+		if !reflect.DeepEqual(result.TOC, want) {
+			t.Errorf("heading links %+v:\ngot  %+v\nwant %+v", links, result.TOC, want)
+		}
+	}
+}
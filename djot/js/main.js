@@ -1,5 +1,5 @@
-// Assumes this script is prepended with the djot.js dist file.
-// See Makefile for details.
+// Assumes this script is prepended with the djot.js dist file and mathml.js.
+// See Makefile and djot.go for details.
 const djot = module.exports;
 let buffer = new Uint8Array();
 const MSG_DELIMITER = 255; // 0xFF which doesn't appear in valid UTF-8
@@ -18,7 +18,21 @@ process.stdin.on("data", (chunk) => {
 
     const msg = buffer.subarray(0, endIndex);
     buffer = buffer.subarray(endIndex + 1);
-    handleMessage(msg);
+    let response;
+    try {
+      response = handleMessage(msg);
+    } catch (err) {
+      // Never let bad input kill the service, otherwise every subsequent
+      // request would fail too.
+      response = {
+        html: "",
+        toc: [],
+        shortcodes: [],
+        errors: [{ msg: `Failed to render djot: ${err}`, line: 0 }],
+      };
+    }
+    const responseBytes = new TextEncoder().encode(JSON.stringify(response));
+    process.stdout.write(concatTypedArray(responseBytes, END));
   }
 });
 
@@ -29,24 +43,255 @@ function concatTypedArray(former, latter) {
   return result;
 }
 
+// Each message is a JSON request: {input, path, xrefs, filters, headingLinks}
+// and returns the response, which is sent back as JSON:
+// {html, toc, shortcodes: [{name, args, line}], errors: [{msg, line, filter}]}
 function handleMessage(msg) {
-  const input = new TextDecoder().decode(msg);
-  const ast = djot.parse(input);
-  djot.applyFilter(ast, createHeadingLinks);
-  const output = djot.renderHTML(ast);
-  const outputBytes = new TextEncoder().encode(output);
-  process.stdout.write(concatTypedArray(outputBytes, END));
+  const req = JSON.parse(new TextDecoder().decode(msg));
+  const errors = [];
+  const shortcodes = [];
+  const ast = djot.parse(req.input, { sourcePositions: true });
+  applyUserFilters(ast, req.filters || [], errors);
+  djot.applyFilter(ast, () => extractShortcodes(shortcodes, errors));
+  // Must build TOC before adding heading links, so that the links' text
+  // doesn't end up in headings' text.
+  const toc = tableOfContents(ast);
+  if (req.headingLinks) {
+    djot.applyFilter(ast, () => createHeadingLinks(req.headingLinks));
+  }
+  djot.applyFilter(ast, () => resolveCrossReferences(ast, req, errors));
+  djot.applyFilter(ast, () => renderMath(errors));
+  // Positions are only needed for error reporting. If left in the AST, the
+  // renderer would turn them into data-startpos attributes.
+  stripPositions(ast);
+  return {
+    html: djot.renderHTML(ast),
+    toc: toc,
+    shortcodes: shortcodes,
+    errors: errors,
+  };
+}
+
+// Compiled user filters: name => {source, compiled}. A filter is only
+// recompiled when its source changes, e.g. when edited during `s4g serve`.
+const compiledFilters = new Map();
+
+function applyUserFilters(ast, filters, errors) {
+  for (const { name, source } of filters) {
+    try {
+      let cached = compiledFilters.get(name);
+      if (!cached || cached.source !== source) {
+        cached = { source, compiled: new Function("djot", source) };
+        compiledFilters.set(name, cached);
+      }
+      const compiled = cached.compiled;
+      djot.applyFilter(ast, () => compiled(djot));
+    } catch (err) {
+      errors.push({
+        msg: String(err),
+        line: filterErrorLine(err),
+        filter: name,
+      });
+    }
+  }
 }
 
-function createHeadingLinks() {
+// Finds the line in a filter's source where err was thrown, or 0 if unknown.
+function filterErrorLine(err) {
+  const m = /<anonymous>:(\d+):\d+/.exec(err.stack || "");
+  // new Function() prepends 2 lines to the source
+  return m ? Math.max(0, Number(m[1]) - 2) : 0;
+}
+
+// Replaces divs and spans with the "shortcode" class with their children,
+// wrapped in numbered markers: <!--s4g-shortcode:N-->...<!--/s4g-shortcode:N-->
+// The actual shortcode templates are executed on the Go side.
+//
+// Filters run on exit, so nested shortcodes always get lower numbers than
+// their parents.
+function extractShortcodes(shortcodes, errors) {
+  const extract = (e) => {
+    const classes = ((e.attributes || {}).class || "").split(" ");
+    if (!classes.includes("shortcode")) {
+      return;
+    }
+    const line = e.pos ? e.pos.start.line : 0;
+    const { name, ...args } = e.attributes;
+    const otherClasses = classes.filter((c) => c !== "shortcode").join(" ");
+    if (otherClasses) {
+      args.class = otherClasses;
+    } else {
+      delete args.class;
+    }
+    if (!name) {
+      errors.push({ msg: 'Shortcode without a "name" attribute', line: line });
+      return;
+    }
+
+    const index = shortcodes.length;
+    shortcodes.push({ name: name, args: args, line: line });
+    const tag = e.tag === "div" ? "raw_block" : "raw_inline";
+    return [
+      { tag: tag, format: "html", text: `<!--s4g-shortcode:${index}-->` },
+      ...e.children,
+      { tag: tag, format: "html", text: `<!--/s4g-shortcode:${index}-->` },
+    ];
+  };
+  return { div: extract, span: extract };
+}
+
+function createHeadingLinks(opts) {
   return {
     section: (e) => {
-      e.children[0].children.push({
+      const heading = e.children[0];
+      if (!opts.levels.includes(heading.level)) {
+        return;
+      }
+      const link = {
         tag: "link",
         destination: "#" + e.attributes.id,
-        children: [{ tag: "str", text: "#" }],
-        attributes: { class: "heading-link" },
-      });
+        children: [{ tag: "raw_inline", format: "html", text: opts.symbol }],
+        attributes: opts.class ? { class: opts.class } : {},
+      };
+      if (opts.before) {
+        heading.children.unshift(link);
+      } else {
+        heading.children.push(link);
+      }
+    },
+  };
+}
+
+// Rewrites links to .dj files into links to their generated pages. Paths are
+// either relative to the current file, or relative to the website's folder if
+// they start with a "/". Empty link text defaults to the target's title.
+function resolveCrossReferences(ast, req, errors) {
+  // Reference definitions are shared between links, so remember their
+  // original destinations before any link gets rewritten.
+  const refDests = {};
+  for (const label in ast.references) {
+    refDests[label] = ast.references[label].destination;
+  }
+
+  return {
+    link: (e) => {
+      const ref = e.reference ? ast.references[e.reference] : undefined;
+      const dest = ref ? refDests[e.reference] : e.destination;
+      if (!dest || !isDjotPath(dest)) {
+        return;
+      }
+
+      const hashIndex = dest.indexOf("#");
+      const destPath = hashIndex === -1 ? dest : dest.slice(0, hashIndex);
+      const fragment = hashIndex === -1 ? "" : dest.slice(hashIndex);
+      let decodedPath;
+      try {
+        decodedPath = decodeURI(destPath);
+      } catch (err) {
+        if (!(err instanceof URIError)) {
+          throw err;
+        }
+        errors.push({
+          msg: `Invalid link "${dest}": malformed escape sequence`,
+          line: e.pos ? e.pos.start.line : 0,
+        });
+        return;
+      }
+      const targetPath = resolveSourcePath(req.path, decodedPath);
+      const target = req.xrefs[targetPath];
+      if (!target) {
+        errors.push({
+          msg: `Link to unknown file "${dest}" (resolved to "${targetPath}")`,
+          line: e.pos ? e.pos.start.line : 0,
+        });
+        return;
+      }
+
+      e.destination = target.url + fragment;
+      if (ref) {
+        delete e.reference;
+        e.attributes = { ...ref.attributes, ...e.attributes };
+      }
+      if (e.children.length === 0) {
+        e.children = [{ tag: "str", text: target.title }];
+      }
     },
   };
 }
+
+// Replaces $`...` and $$`...` math with MathML.
+function renderMath(errors) {
+  const convert = (e) => {
+    try {
+      return {
+        tag: "raw_inline",
+        format: "html",
+        text: texToMathML(e.text, e.tag === "display_math"),
+      };
+    } catch (err) {
+      if (!(err instanceof TexError)) {
+        throw err;
+      }
+      errors.push({
+        msg: `Invalid math "${e.text}": ${err.message}`,
+        line: e.pos ? e.pos.start.line : 0,
+      });
+    }
+  };
+  return { inline_math: convert, display_math: convert };
+}
+
+function isDjotPath(dest) {
+  const path = dest.split("#")[0];
+  return path.endsWith(".dj") && !/^[a-zA-Z][a-zA-Z0-9+.-]*:|^\/\//.test(path);
+}
+
+function resolveSourcePath(currentPath, dest) {
+  const posix = require("path").posix;
+  if (dest.startsWith("/")) {
+    return posix.normalize(dest.slice(1));
+  }
+  return posix.join(posix.dirname(currentPath), dest);
+}
+
+function stripPositions(node) {
+  delete node.pos;
+  for (const key of ["children", "references", "footnotes"]) {
+    for (const child of Object.values(node[key] || {})) {
+      stripPositions(child);
+    }
+  }
+}
+
+// Returns nested list of {level, text, id, children} from section nodes,
+// which djot already nests according to heading levels.
+function tableOfContents(node) {
+  const entries = [];
+  for (const child of node.children || []) {
+    if (child.tag !== "section") {
+      continue;
+    }
+    const heading = child.children[0];
+    entries.push({
+      level: heading.level,
+      text: textContent(heading),
+      id: child.attributes.id,
+      children: tableOfContents(child),
+    });
+  }
+  return entries;
+}
+
+// Raw nodes are skipped: they're html rather than text, e.g. shortcode markers.
+function textContent(node) {
+  if (node.tag === "soft_break" || node.tag === "hard_break") {
+    return " ";
+  }
+  if (node.tag === "raw_inline" || node.tag === "raw_block") {
+    return "";
+  }
+  if (node.text !== undefined) {
+    return node.text;
+  }
+  return (node.children || []).map(textContent).join("");
+}
@@ -0,0 +1,557 @@
+// Converts a practical subset of LaTeX math into MathML, so that math can be
+// displayed without any client-side javascript.
+//
+// Throws a TexError on invalid or unsupported input.
+
+class TexError extends Error {}
+
+const TEX_GREEK = {
+  alpha: "α", beta: "β", gamma: "γ", delta: "δ", epsilon: "ϵ",
+  varepsilon: "ε", zeta: "ζ", eta: "η", theta: "θ", vartheta: "ϑ",
+  iota: "ι", kappa: "κ", lambda: "λ", mu: "μ", nu: "ν", xi: "ξ",
+  pi: "π", varpi: "ϖ", rho: "ρ", varrho: "ϱ", sigma: "σ", varsigma: "ς",
+  tau: "τ", upsilon: "υ", phi: "ϕ", varphi: "φ", chi: "χ", psi: "ψ",
+  omega: "ω",
+  Gamma: "Γ", Delta: "Δ", Theta: "Θ", Lambda: "Λ", Xi: "Ξ", Pi: "Π",
+  Sigma: "Σ", Upsilon: "Υ", Phi: "Φ", Psi: "Ψ", Omega: "Ω",
+};
+
+// Symbols that are rendered as <mi>
+const TEX_IDENTIFIERS = {
+  infty: "∞", partial: "∂", nabla: "∇", hbar: "ℏ", ell: "ℓ", emptyset: "∅",
+  varnothing: "∅", aleph: "ℵ", Re: "ℜ", Im: "ℑ", wp: "℘", dots: "…",
+  ldots: "…", cdots: "⋯", vdots: "⋮", ddots: "⋱", prime: "′",
+  top: "⊤", bot: "⊥", angle: "∠", triangle: "△", forall: "∀",
+  exists: "∃", neg: "¬", lnot: "¬", "%": "%", $: "$", "#": "#", "&": "&",
+  _: "_",
+};
+
+// Symbols that are rendered as <mo>
+const TEX_OPERATORS = {
+  pm: "±", mp: "∓", times: "×", div: "÷", cdot: "⋅", ast: "∗", star: "⋆",
+  circ: "∘", bullet: "∙", oplus: "⊕", ominus: "⊖", otimes: "⊗",
+  odot: "⊙", cap: "∩", cup: "∪", setminus: "∖", wedge: "∧", land: "∧",
+  vee: "∨", lor: "∨", leq: "≤", le: "≤", geq: "≥", ge: "≥", neq: "≠",
+  ne: "≠", approx: "≈", equiv: "≡", sim: "∼", simeq: "≃", cong: "≅",
+  propto: "∝", ll: "≪", gg: "≫", in: "∈", notin: "∉", ni: "∋",
+  subset: "⊂", supset: "⊃", subseteq: "⊆", supseteq: "⊇", mid: "∣",
+  parallel: "∥", perp: "⟂", to: "→", rightarrow: "→", leftarrow: "←",
+  gets: "←", leftrightarrow: "↔", Rightarrow: "⇒", Leftarrow: "⇐",
+  Leftrightarrow: "⇔", implies: "⟹", iff: "⟺", mapsto: "↦",
+  uparrow: "↑", downarrow: "↓", langle: "⟨", rangle: "⟩",
+  lfloor: "⌊", rfloor: "⌋", lceil: "⌈", rceil: "⌉", vert: "|",
+  Vert: "‖", "{": "{", "}": "}", "|": "‖", colon: ":",
+};
+
+// Big operators, whose limits go above/below them in display mode
+const TEX_BIG_OPERATORS = {
+  sum: "∑", prod: "∏", coprod: "∐", int: "∫", iint: "∬", iiint: "∭",
+  oint: "∮", bigcup: "⋃", bigcap: "⋂", bigoplus: "⨁", bigotimes: "⨂",
+  bigvee: "⋁", bigwedge: "⋀",
+};
+
+const TEX_FUNCTIONS = [
+  "sin", "cos", "tan", "cot", "sec", "csc", "arcsin", "arccos", "arctan",
+  "sinh", "cosh", "tanh", "coth", "log", "ln", "lg", "exp", "det", "dim",
+  "ker", "deg", "gcd", "hom", "arg", "Pr",
+];
+
+// Functions whose limits behave like big operators'
+const TEX_LIMIT_FUNCTIONS = [
+  "lim", "liminf", "limsup", "max", "min", "sup", "inf",
+];
+
+const TEX_ACCENTS = {
+  hat: "^", widehat: "^", bar: "¯", overline: "¯", vec: "→",
+  overrightarrow: "→", dot: "˙", ddot: "¨", tilde: "~", widetilde: "~",
+  check: "ˇ", breve: "˘", acute: "´", grave: "`",
+};
+
+const TEX_SPACES = {
+  ",": "0.1667em", ":": "0.2222em", ">": "0.2222em", ";": "0.2778em",
+  "!": "-0.1667em", " ": "0.25em", quad: "1em", qquad: "2em",
+};
+
+// [open, close] delimiters of matrix environments
+const TEX_MATRICES = {
+  matrix: ["", ""], pmatrix: ["(", ")"], bmatrix: ["[", "]"],
+  Bmatrix: ["{", "}"], vmatrix: ["|", "|"], Vmatrix: ["‖", "‖"],
+  cases: ["{", ""], aligned: ["", ""], align: ["", ""], "align*": ["", ""],
+  gathered: ["", ""], array: ["", ""],
+};
+
+// Code points of "A" and "a" in the Mathematical Alphanumeric Symbols block,
+// along with letters that live elsewhere because they existed before it.
+const TEX_ALPHABETS = {
+  mathbf: { upper: 0x1d400, lower: 0x1d41a, digits: 0x1d7ce },
+  mathbb: {
+    upper: 0x1d538, lower: 0x1d552, digits: 0x1d7d8,
+    exceptions: { C: "ℂ", H: "ℍ", N: "ℕ", P: "ℙ", Q: "ℚ", R: "ℝ", Z: "ℤ" },
+  },
+  mathcal: {
+    upper: 0x1d49c, lower: 0x1d4b6,
+    exceptions: {
+      B: "ℬ", E: "ℰ", F: "ℱ", H: "ℋ", I: "ℐ", L: "ℒ", M: "ℳ", R: "ℛ",
+      e: "ℯ", g: "ℊ", o: "ℴ",
+    },
+  },
+  mathfrak: {
+    upper: 0x1d504, lower: 0x1d51e,
+    exceptions: { C: "ℭ", H: "ℌ", I: "ℑ", R: "ℜ", Z: "ℨ" },
+  },
+  mathsf: { upper: 0x1d5a0, lower: 0x1d5ba, digits: 0x1d7e2 },
+  mathtt: { upper: 0x1d670, lower: 0x1d68a, digits: 0x1d7f6 },
+};
+
+function texToMathML(tex, displayMode) {
+  let pos = 0;
+
+  function fail(msg) {
+    throw new TexError(msg);
+  }
+
+  function skipSpaces() {
+    while (pos < tex.length && /\s/.test(tex[pos])) {
+      pos++;
+    }
+  }
+
+  function peek() {
+    skipSpaces();
+    if (pos >= tex.length) {
+      return null;
+    }
+    if (tex[pos] !== "\\") {
+      return tex[pos];
+    }
+    const m = /^\\([a-zA-Z]+\*?|.)/.exec(tex.slice(pos));
+    if (!m) {
+      fail("Math ends with a lone backslash");
+    }
+    return m[0];
+  }
+
+  function next() {
+    const token = peek();
+    if (token !== null) {
+      pos += token.length;
+    }
+    return token;
+  }
+
+  function expect(token) {
+    const actual = peek();
+    if (actual !== token) {
+      fail(`Expected "${token}" but found ${describe(actual)}`);
+    }
+    next();
+  }
+
+  function describe(token) {
+    return token === null ? "end of math" : `"${token}"`;
+  }
+
+  // Reads a {group} verbatim, for things like \text and \begin.
+  function readRawGroup() {
+    expect("{");
+    let depth = 1;
+    const start = pos;
+    while (pos < tex.length) {
+      if (tex[pos] === "\\") {
+        pos += 2;
+        continue;
+      }
+      if (tex[pos] === "{") {
+        depth++;
+      } else if (tex[pos] === "}") {
+        depth--;
+        if (depth === 0) {
+          pos++;
+          return tex.slice(start, pos - 1);
+        }
+      }
+      pos++;
+    }
+    fail('Missing closing "}"');
+  }
+
+  // Reads an optional [argument], e.g. for \sqrt[3]{x}
+  function readOptionalArg() {
+    if (peek() !== "[") {
+      return null;
+    }
+    next();
+    const items = parseList(["]"]);
+    expect("]");
+    return row(items);
+  }
+
+  // Parses a sequence of atoms until one of the stop tokens or end of input.
+  function parseList(stops) {
+    const items = [];
+    while (true) {
+      const token = peek();
+      if (token === null || stops.includes(token)) {
+        return items;
+      }
+      if (token === "}") {
+        fail('Unexpected "}"');
+      }
+      if (token === "&" || token === "\\\\") {
+        fail(`"${token}" is only allowed inside an environment such as \\begin{aligned}`);
+      }
+      if (token === "\\right") {
+        fail('"\\right" without matching "\\left"');
+      }
+      if (token === "\\end") {
+        fail('"\\end" without matching "\\begin"');
+      }
+      items.push(parseScripts(parseAtom()));
+    }
+  }
+
+  // Parses a command's mandatory argument: either a {group} or a single atom.
+  function parseArg(command) {
+    const token = peek();
+    if (token === null || token === "}" || token === "&" || token === "\\\\") {
+      fail(`Missing argument for "${command}"`);
+    }
+    return parseAtom();
+  }
+
+  function parseScripts(base) {
+    let sub = null;
+    let sup = null;
+    while (true) {
+      const token = peek();
+      if (token === "_" || token === "^") {
+        next();
+        if ((token === "_" && sub) || (token === "^" && sup)) {
+          fail(`Double ${token === "_" ? "subscript" : "superscript"}`);
+        }
+        const script = parseArg(token);
+        if (token === "_") {
+          sub = script;
+        } else {
+          sup = script;
+        }
+      } else if (token === "'") {
+        next();
+        sup = sup ? row([sup, mo("′")]) : mo("′");
+      } else {
+        break;
+      }
+    }
+    if (!sub && !sup) {
+      return base;
+    }
+
+    const useLimits = base.limits && displayMode;
+    if (sub && sup) {
+      return node(useLimits ? "munderover" : "msubsup", [base, sub, sup]);
+    }
+    if (sub) {
+      return node(useLimits ? "munder" : "msub", [base, sub]);
+    }
+    return node(useLimits ? "mover" : "msup", [base, sup]);
+  }
+
+  function parseAtom() {
+    const token = next();
+
+    if (token === "{") {
+      const items = parseList(["}"]);
+      expect("}");
+      return row(items);
+    }
+    if (token === "_" || token === "^") {
+      // Script without a base, e.g. {}^{14}C
+      pos -= token.length;
+      return row([]);
+    }
+    if (/^[0-9.]$/.test(token)) {
+      let num = token;
+      while (pos < tex.length && /[0-9.]/.test(tex[pos])) {
+        num += tex[pos++];
+      }
+      return leaf("mn", num);
+    }
+    if (/^[a-zA-Z]$/.test(token)) {
+      return leaf("mi", token);
+    }
+    if (token === "-") {
+      return mo("−");
+    }
+    if (token === "*") {
+      return mo("∗");
+    }
+    if (token === "~") {
+      return space(TEX_SPACES[" "]);
+    }
+    if (!token.startsWith("\\")) {
+      if (/^[+=<>()[\]|/,;:!?]$/.test(token)) {
+        return mo(token);
+      }
+      return leaf("mi", token);
+    }
+
+    return parseCommand(token, token.slice(1));
+  }
+
+  function parseCommand(token, name) {
+    if (name in TEX_GREEK) {
+      return leaf("mi", TEX_GREEK[name]);
+    }
+    if (name in TEX_IDENTIFIERS) {
+      return leaf("mi", TEX_IDENTIFIERS[name]);
+    }
+    if (name in TEX_OPERATORS) {
+      return mo(TEX_OPERATORS[name]);
+    }
+    if (name in TEX_BIG_OPERATORS) {
+      const op = mo(TEX_BIG_OPERATORS[name], { largeop: "true" });
+      // Integrals keep their limits on the side
+      op.limits = !name.includes("int");
+      return op;
+    }
+    if (TEX_FUNCTIONS.includes(name)) {
+      return leaf("mi", name);
+    }
+    if (TEX_LIMIT_FUNCTIONS.includes(name)) {
+      const fn = leaf("mi", name);
+      fn.limits = true;
+      return fn;
+    }
+    if (name in TEX_SPACES) {
+      return space(TEX_SPACES[name]);
+    }
+    if (name in TEX_ACCENTS) {
+      const base = parseArg(token);
+      const stretchy = name.startsWith("wide") || name.startsWith("over");
+      const accent = mo(TEX_ACCENTS[name], { stretchy: String(stretchy) });
+      return node("mover", [base, accent], { accent: "true" });
+    }
+    if (name in TEX_ALPHABETS) {
+      return convertAlphabet(parseArg(token), TEX_ALPHABETS[name]);
+    }
+
+    switch (name) {
+      case "frac":
+      case "dfrac":
+      case "tfrac": {
+        const num = parseArg(token);
+        const den = parseArg(token);
+        return node("mfrac", [num, den]);
+      }
+      case "binom": {
+        const top = parseArg(token);
+        const bottom = parseArg(token);
+        return row([
+          mo("("),
+          node("mfrac", [top, bottom], { linethickness: "0" }),
+          mo(")"),
+        ]);
+      }
+      case "sqrt": {
+        const index = readOptionalArg();
+        const radicand = parseArg(token);
+        return index
+          ? node("mroot", [radicand, index])
+          : node("msqrt", [radicand]);
+      }
+      case "underline":
+        return node("munder", [parseArg(token), mo("_", { stretchy: "true" })], {
+          accentunder: "true",
+        });
+      case "text":
+      case "textrm":
+      case "mbox":
+        return leaf("mtext", readRawGroup().replace(/\\([{}$%#&_])/g, "$1"));
+      case "mathrm":
+      case "operatorname":
+        return leaf("mi", readRawGroup().replace(/\s/g, ""), {
+          mathvariant: "normal",
+        });
+      case "mathit":
+        return parseArg(token);
+      case "left":
+        return parseLeftRight();
+      case "begin":
+        return parseEnvironment();
+      case "displaystyle":
+      case "limits":
+      case "nolimits":
+        return row([]);
+      default:
+        fail(`Unsupported command "${token}"`);
+    }
+  }
+
+  function parseDelimiter(command) {
+    const token = next();
+    if (token === null) {
+      fail(`Missing delimiter after "${command}"`);
+    }
+    if (token === ".") {
+      return null;
+    }
+    if (token.startsWith("\\")) {
+      const symbol = TEX_OPERATORS[token.slice(1)];
+      if (!symbol) {
+        fail(`Invalid delimiter "${token}" after "${command}"`);
+      }
+      return symbol;
+    }
+    if (!/^[()[\]|/]$/.test(token)) {
+      fail(`Invalid delimiter "${token}" after "${command}"`);
+    }
+    return token;
+  }
+
+  function parseLeftRight() {
+    const open = parseDelimiter("\\left");
+    const items = parseList(["\\right"]);
+    if (peek() !== "\\right") {
+      fail('"\\left" without matching "\\right"');
+    }
+    next();
+    const close = parseDelimiter("\\right");
+    return fenced(open, items, close);
+  }
+
+  function parseEnvironment() {
+    const env = readRawGroup().trim();
+    if (!(env in TEX_MATRICES)) {
+      fail(`Unsupported environment "${env}"`);
+    }
+    if (env === "array") {
+      // Column alignment spec isn't supported, but shouldn't be rendered
+      readRawGroup();
+    }
+
+    const rows = [];
+    let cells = [];
+    while (true) {
+      cells.push(node("mtd", [row(parseList(["&", "\\\\", "\\end"]))]));
+      const token = next();
+      if (token === "&") {
+        continue;
+      }
+      rows.push(node("mtr", cells));
+      cells = [];
+      if (token === "\\end") {
+        break;
+      }
+      if (token === null) {
+        fail(`"\\begin{${env}}" without matching "\\end{${env}}"`);
+      }
+    }
+    const endEnv = readRawGroup().trim();
+    if (endEnv !== env) {
+      fail(`"\\begin{${env}}" ended by "\\end{${endEnv}}"`);
+    }
+
+    const attrs = {};
+    if (env.startsWith("align") || env === "cases") {
+      attrs.columnalign = env === "cases" ? "left left" : "right left";
+    }
+    const table = node("mtable", rows, attrs);
+    const [open, close] = TEX_MATRICES[env];
+    if (!open && !close) {
+      return table;
+    }
+    return fenced(open || null, [table], close || null);
+  }
+
+  function fenced(open, items, close) {
+    const children = [];
+    if (open) {
+      children.push(mo(open, { fence: "true", stretchy: "true" }));
+    }
+    children.push(...items);
+    if (close) {
+      children.push(mo(close, { fence: "true", stretchy: "true" }));
+    }
+    return row(children);
+  }
+
+  function convertAlphabet(n, alphabet) {
+    if (n.text === undefined) {
+      n.children = n.children.map((c) => convertAlphabet(c, alphabet));
+      return n;
+    }
+    n.text = Array.from(n.text)
+      .map((c) => {
+        if (alphabet.exceptions && c in alphabet.exceptions) {
+          return alphabet.exceptions[c];
+        }
+        if (c >= "A" && c <= "Z") {
+          return String.fromCodePoint(alphabet.upper + c.charCodeAt(0) - 65);
+        }
+        if (c >= "a" && c <= "z") {
+          return String.fromCodePoint(alphabet.lower + c.charCodeAt(0) - 97);
+        }
+        if (c >= "0" && c <= "9" && alphabet.digits) {
+          return String.fromCodePoint(alphabet.digits + c.charCodeAt(0) - 48);
+        }
+        return c;
+      })
+      .join("");
+    if (n.tag === "mi") {
+      // Otherwise single letters would be italicized on top
+      n.attrs.mathvariant = "normal";
+    }
+    return n;
+  }
+
+  const items = parseList([]);
+  const annotation = leaf("annotation", tex, {
+    encoding: "application/x-tex",
+  });
+  const math = node(
+    "math",
+    [node("semantics", [row(items), annotation])],
+    displayMode ? { display: "block" } : {}
+  );
+  return renderMathNode(math);
+}
+
+function node(tag, children, attrs) {
+  return { tag, children, attrs: attrs || {} };
+}
+
+function leaf(tag, text, attrs) {
+  return { tag, text, attrs: attrs || {} };
+}
+
+function mo(text, attrs) {
+  return leaf("mo", text, attrs);
+}
+
+function row(items) {
+  return items.length === 1 ? items[0] : node("mrow", items);
+}
+
+function space(width) {
+  return node("mspace", [], { width });
+}
+
+function renderMathNode(n) {
+  let attrs = "";
+  for (const [key, val] of Object.entries(n.attrs)) {
+    attrs += ` ${key}="${escapeXml(val)}"`;
+  }
+  const content =
+    n.text !== undefined
+      ? escapeXml(n.text)
+      : n.children.map(renderMathNode).join("");
+  return `<${n.tag}${attrs}>${content}</${n.tag}>`;
+}
+
+function escapeXml(s) {
+  return s
+    .replace(/&/g, "&amp;")
+    .replace(/</g, "&lt;")
+    .replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;");
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"encoding/xml"
+	"fmt"
+	"net/mail"
+	"net/url"
 	"strings"
 
 	"golang.org/x/tools/blog/atom"
@@ -14,17 +17,37 @@ func generateFeed(site *SiteMetadata, posts []*Article, path string) []byte {
 	if !strings.HasSuffix(siteAddr, "/") {
 		siteAddr += "/"
 	}
+	// Summaries' links are relative to the website's root, which won't work
+	// in feed readers.
+	addrURL, err := url.Parse(siteAddr)
+	if err != nil {
+		panic(err)
+	}
 	var entries []*atom.Entry
 	for _, p := range posts {
 		// trim WebPath's leading slash because siteAddr already has one
 		link := siteAddr + p.WebPath[1:]
-		entries = append(entries, &atom.Entry{
+		entry := &atom.Entry{
 			ID:        link,
 			Link:      []atom.Link{{Href: link}},
 			Title:     p.Title,
 			Published: atom.Time(p.PostedAt),
 			Updated:   atom.Time(p.PostedAt),
-		})
+		}
+		if p.Summary != "" {
+			entry.Summary = &atom.Text{
+				Type: "html",
+				Body: resolveLinks(string(p.Summary), addrURL),
+			}
+		}
+		// Only override the feed-level author when it's actually different
+		if p.Author != site.AuthorName || p.AuthorEmail != site.AuthorEmail {
+			entry.Author = &atom.Person{
+				Name:  p.Author,
+				Email: p.AuthorEmail,
+			}
+		}
+		entries = append(entries, entry)
 	}
 
 	feed := atom.Feed{
@@ -37,7 +60,8 @@ func generateFeed(site *SiteMetadata, posts []*Article, path string) []byte {
 			URI:   site.AuthorURI,
 			Email: site.AuthorEmail,
 		},
-		Link: []atom.Link{{Rel: "self", Href: path}},
+		// path already has a leading slash, just like WebPath
+		Link: []atom.Link{{Rel: "self", Href: siteAddr + path[1:]}},
 	}
 
 	result, err := xml.MarshalIndent(feed, "", "  ")
@@ -46,3 +70,91 @@ func generateFeed(site *SiteMetadata, posts []*Article, path string) []byte {
 	}
 	return result
 }
+
+// Checks generated feed against the most common reasons feed validators
+// complain. Problems are returned as human-readable warnings instead of
+// errors because the feed is still usable by most readers.
+func validateFeed(data []byte) (warnings []string) {
+	var feed atom.Feed
+	err := xml.Unmarshal(data, &feed)
+	if err != nil {
+		return []string{fmt.Sprintf("feed is not valid XML: %s", err)}
+	}
+
+	warn := func(format string, a ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, a...))
+	}
+
+	if !isAbsoluteURL(feed.ID) {
+		warn(`feed id "%s" is not an absolute URL, check your Address setting`, feed.ID)
+	}
+	if feed.Title == "" {
+		warn("feed has no title, check your Name setting")
+	}
+	if feed.Updated == "" || strings.HasPrefix(string(feed.Updated), "0001-") {
+		warn("feed has no valid updated date")
+	}
+
+	for _, link := range feed.Link {
+		if link.Rel == "self" && !isAbsoluteURL(link.Href) {
+			warn(`feed self link "%s" is not an absolute URL`, link.Href)
+		}
+	}
+
+	feedHasAuthor := feed.Author != nil && feed.Author.Name != ""
+	if feed.Author != nil {
+		warnings = append(warnings, validatePerson("feed", feed.Author)...)
+	}
+
+	ids := make(map[string]bool)
+	for _, e := range feed.Entry {
+		name := fmt.Sprintf(`entry "%s"`, e.Title)
+		if e.Title == "" {
+			name = fmt.Sprintf(`entry "%s"`, e.ID)
+			warn("%s has no title", name)
+		}
+		if !isAbsoluteURL(e.ID) {
+			warn(`%s: id "%s" is not an absolute URL`, name, e.ID)
+		}
+		if ids[e.ID] {
+			warn(`%s: duplicate id "%s"`, name, e.ID)
+		}
+		ids[e.ID] = true
+
+		if strings.HasPrefix(string(e.Updated), "0001-") {
+			warn("%s has no PostedAt date", name)
+		}
+
+		if e.Author != nil {
+			warnings = append(warnings, validatePerson(name, e.Author)...)
+		} else if !feedHasAuthor {
+			warn("%s has no author, and neither does the feed", name)
+		}
+	}
+
+	return warnings
+}
+
+func validatePerson(owner string, p *atom.Person) (warnings []string) {
+	if p.Name == "" {
+		warnings = append(warnings, fmt.Sprintf("%s: author has no name", owner))
+	}
+	if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			warnings = append(warnings, fmt.Sprintf(
+				`%s: invalid author email "%s"`, owner, p.Email,
+			))
+		}
+	}
+	if p.URI != "" && !isAbsoluteURL(p.URI) {
+		warnings = append(warnings, fmt.Sprintf(
+			`%s: author URI "%s" is not an absolute URL`, owner, p.URI,
+		))
+	}
+	return warnings
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestComputeDerivedFieldsAuthor(t *testing.T) {
+	site := NewSiteMetadata()
+	tests := []struct {
+		name, author, email   string
+		wantAuthor, wantEmail string
+	}{
+		{"inherits both", "", "", site.AuthorName, site.AuthorEmail},
+		{"guest author", "Guest", "", "Guest", ""},
+		{"guest author with email", "Guest", "guest@example.com", "Guest", "guest@example.com"},
+		{"email only", "", "guest@example.com", "", "guest@example.com"},
+	}
+	for _, tt := range tests {
+		a := &Article{OutputPath: "post.html"}
+		a.Author, a.AuthorEmail = tt.author, tt.email
+		a.ComputeDerivedFields(&site)
+		if a.Author != tt.wantAuthor || a.AuthorEmail != tt.wantEmail {
+			t.Errorf(
+				"%s: got %q <%s>, want %q <%s>",
+				tt.name, a.Author, a.AuthorEmail, tt.wantAuthor, tt.wantEmail,
+			)
+		}
+	}
+}
+
+func TestGenerateFeedAuthors(t *testing.T) {
+	site := NewSiteMetadata()
+	posted := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	newPost := func(path, author, email string) *Article {
+		a := &Article{OutputPath: path}
+		a.Title = path
+		a.PostedAt = posted
+		a.Author, a.AuthorEmail = author, email
+		a.ComputeDerivedFields(&site)
+		return a
+	}
+	posts := []*Article{
+		newPost("site.html", "", ""),
+		newPost("guest.html", "Guest", "guest@example.com"),
+	}
+
+	data := generateFeed(&site, posts, "/feed.xml")
+	var feed atom.Feed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+
+	wantFeedAuthor := &atom.Person{
+		Name:  site.AuthorName,
+		URI:   site.AuthorURI,
+		Email: site.AuthorEmail,
+	}
+	if !samePerson(feed.Author, wantFeedAuthor) {
+		t.Errorf("feed author = %+v, want %+v", feed.Author, wantFeedAuthor)
+	}
+	// The site's own posts fall back to the feed-level author
+	if feed.Entry[0].Author != nil {
+		t.Errorf("site.html author = %+v, want none", feed.Entry[0].Author)
+	}
+	wantGuest := &atom.Person{Name: "Guest", Email: "guest@example.com"}
+	if !samePerson(feed.Entry[1].Author, wantGuest) {
+		t.Errorf("guest.html author = %+v, want %+v", feed.Entry[1].Author, wantGuest)
+	}
+
+	if warnings := validateFeed(data); len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
+
+func samePerson(a, b *atom.Person) bool {
+	return a != nil && b != nil && a.Name == b.Name && a.URI == b.URI && a.Email == b.Email
+}
+
+func TestValidateFeed(t *testing.T) {
+	const header = `<feed xmlns="http://www.w3.org/2005/Atom">` +
+		`<title>Site</title><updated>2024-03-05T00:00:00Z</updated>`
+	tests := []struct {
+		name string
+		feed string
+		want []string
+	}{
+		{
+			name: "relative id and self link",
+			feed: header + `<id>/blog/</id><link rel="self" href="/blog/feed.xml"></link>` +
+				`<author><name>A</name></author></feed>`,
+			want: []string{
+				`feed id "/blog/" is not an absolute URL, check your Address setting`,
+				`feed self link "/blog/feed.xml" is not an absolute URL`,
+			},
+		},
+		{
+			name: "bad email",
+			feed: header + `<id>https://example.com/</id>` +
+				`<author><name>A</name><email>not an email</email></author>` +
+				`<entry><id>https://example.com/a</id><title>A</title>` +
+				`<updated>2024-03-05T00:00:00Z</updated>` +
+				`<author><name>B</name><email>b@</email></author></entry></feed>`,
+			want: []string{
+				`feed: invalid author email "not an email"`,
+				`entry "A": invalid author email "b@"`,
+			},
+		},
+		{
+			name: "duplicate ids",
+			feed: header + `<id>https://example.com/</id><author><name>A</name></author>` +
+				`<entry><id>https://example.com/a</id><title>A</title>` +
+				`<updated>2024-03-05T00:00:00Z</updated></entry>` +
+				`<entry><id>https://example.com/a</id><title>B</title>` +
+				`<updated>2024-03-05T00:00:00Z</updated></entry></feed>`,
+			want: []string{`entry "B": duplicate id "https://example.com/a"`},
+		},
+		{
+			name: "no author anywhere",
+			feed: header + `<id>https://example.com/</id>` +
+				`<entry><id>https://example.com/a</id><title>A</title>` +
+				`<updated>2024-03-05T00:00:00Z</updated></entry></feed>`,
+			want: []string{`entry "A" has no author, and neither does the feed`},
+		},
+		{
+			name: "not xml",
+			feed: "<feed",
+			want: []string{"feed is not valid XML: XML syntax error on line 1: unexpected EOF"},
+		},
+	}
+	for _, tt := range tests {
+		got := validateFeed([]byte(tt.feed))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
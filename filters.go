@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"go.imnhan.com/s4g/djot"
+	"go.imnhan.com/s4g/errs"
+	"go.imnhan.com/s4g/writablefs"
+)
+
+var FiltersPath = S4gDir + "/filters"
+
+// Reads user-defined djot filters from FiltersPath, in the order given by
+// the DjotFilters setting. If that's not set, every .js file in FiltersPath
+// is used, in alphabetical order.
+func readDjotFilters(
+	fsys writablefs.FS, site *SiteMetadata,
+) ([]djot.Filter, *errs.UserErr) {
+	var names []string
+	for _, name := range site.DjotFilters {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		entries, err := fs.ReadDir(fsys, FiltersPath)
+		if err != nil {
+			// No filters folder means no filters
+			return nil, nil
+		}
+		for _, e := range entries {
+			if !e.IsDir() && path.Ext(e.Name()) == ".js" {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+	}
+
+	filters := make([]djot.Filter, 0, len(names))
+	for _, name := range names {
+		if strings.Contains(name, "/") {
+			return nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "DjotFilters",
+				Msg: fmt.Sprintf(
+					`"%s" must be a file name in %s, not a path`, name, FiltersPath,
+				),
+			}
+		}
+		source, err := fs.ReadFile(fsys, FiltersPath+"/"+name)
+		if err != nil {
+			return nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "DjotFilters",
+				Msg:   fmt.Sprintf(`"%s" not found in %s`, name, FiltersPath),
+			}
+		}
+		filters = append(filters, djot.Filter{Name: name, Source: string(source)})
+	}
+	return filters, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadDjotFilters(t *testing.T) {
+	files := map[string]string{
+		FiltersPath + "/b.js":      "b",
+		FiltersPath + "/a.js":      "a",
+		FiltersPath + "/c.js":      "c",
+		FiltersPath + "/notes.txt": "not a filter",
+	}
+	tests := []struct {
+		setting   []string
+		wantNames []string
+		wantErr   string
+	}{
+		{nil, []string{"a.js", "b.js", "c.js"}, ""},
+		{[]string{""}, []string{"a.js", "b.js", "c.js"}, ""},
+		{[]string{"c.js", "a.js"}, []string{"c.js", "a.js"}, ""},
+		{[]string{"missing.js"}, nil, `"missing.js" not found in ` + FiltersPath},
+		{
+			[]string{"sub/a.js"}, nil,
+			`"sub/a.js" must be a file name in ` + FiltersPath + `, not a path`,
+		},
+	}
+	fsys := newTestFS(t, files)
+	for _, tt := range tests {
+		site := NewSiteMetadata()
+		site.DjotFilters = tt.setting
+		filters, uerr := readDjotFilters(fsys, &site)
+		if tt.wantErr != "" {
+			if uerr == nil || uerr.Msg != tt.wantErr || uerr.Field != "DjotFilters" {
+				t.Errorf("%v: got error %+v, want %q", tt.setting, uerr, tt.wantErr)
+			}
+			continue
+		}
+		if uerr != nil {
+			t.Errorf("%v: unexpected error %+v", tt.setting, uerr)
+			continue
+		}
+		var names []string
+		for _, f := range filters {
+			names = append(names, f.Name)
+			if f.Source != f.Name[:1] {
+				t.Errorf("%s source = %q", f.Name, f.Source)
+			}
+		}
+		if !reflect.DeepEqual(names, tt.wantNames) {
+			t.Errorf("%v: got %v, want %v", tt.setting, names, tt.wantNames)
+		}
+	}
+
+	// No filters folder means no filters
+	site := NewSiteMetadata()
+	filters, uerr := readDjotFilters(newTestFS(t, map[string]string{}), &site)
+	if len(filters) != 0 || uerr != nil {
+		t.Errorf("without filters folder: got %v, %+v", filters, uerr)
+	}
+}
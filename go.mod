@@ -3,8 +3,15 @@ module go.imnhan.com/s4g
 go 1.21
 
 require (
+	github.com/alecthomas/chroma/v2 v2.10.0
 	github.com/fsnotify/fsnotify v1.6.0
+	golang.org/x/image v0.11.0
+	golang.org/x/net v0.14.0
 	golang.org/x/tools v0.12.0
 )
 
-require golang.org/x/sys v0.11.0 // indirect
+require (
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	golang.org/x/sys v0.11.0 // indirect
+	golang.org/x/text v0.12.0 // indirect
+)
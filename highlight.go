@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+
+	"go.imnhan.com/s4g/errs"
+)
+
+// Generated from the HighlightStyle setting. It lives next to the default
+// theme's assets rather than in the theme folder, so that a highlight.css the
+// user put in the theme folder is never overwritten, and overrides this one.
+var HighlightCSSPath = DefaultThemePath + "/highlight.css"
+
+// Matches djot's output for code blocks that specify a language,
+// e.g. ```go
+var codeBlockRegex = regexp.MustCompile(
+	`(?s)<pre([^>]*)><code class="language-([^"]+)">(.*?)</code></pre>`,
+)
+
+var highlightFormatter = chromahtml.New(
+	chromahtml.WithClasses(true),
+	chromahtml.PreventSurroundingPre(true),
+)
+
+// Returns the chroma style named by the HighlightStyle setting,
+// or nil if highlighting is disabled.
+func highlightStyle(site *SiteMetadata) (*chroma.Style, *errs.UserErr) {
+	if site.HighlightStyle == "" {
+		return nil, nil
+	}
+	style, ok := styles.Registry[site.HighlightStyle]
+	if !ok {
+		names := make([]string, 0, len(styles.Registry))
+		for name := range styles.Registry {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, &errs.UserErr{
+			File:  SettingsPath,
+			Field: "HighlightStyle",
+			Msg: fmt.Sprintf(
+				`unknown style "%s", expected one of: %s`,
+				site.HighlightStyle, strings.Join(names, ", "),
+			),
+		}
+	}
+	return style, nil
+}
+
+// Replaces every code block's plain text with syntax highlighted html.
+// Code blocks in languages that chroma doesn't know are left as-is.
+func highlightCode(content string, style *chroma.Style) string {
+	return codeBlockRegex.ReplaceAllStringFunc(content, func(match string) string {
+		groups := codeBlockRegex.FindStringSubmatch(match)
+		preAttrs, lang, code := groups[1], groups[2], groups[3]
+
+		lexer := lexers.Get(lang)
+		if lexer == nil {
+			return match
+		}
+		lexer = chroma.Coalesce(lexer)
+
+		iterator, err := lexer.Tokenise(nil, html.UnescapeString(code))
+		if err != nil {
+			return match
+		}
+		var highlighted bytes.Buffer
+		err = highlightFormatter.Format(&highlighted, style, iterator)
+		if err != nil {
+			return match
+		}
+
+		// Add chroma's class to <pre>, keeping any class set in djot
+		if strings.Contains(preAttrs, ` class="`) {
+			preAttrs = strings.Replace(preAttrs, ` class="`, ` class="chroma `, 1)
+		} else {
+			preAttrs += ` class="chroma"`
+		}
+
+		return fmt.Sprintf(
+			`<pre%s><code class="language-%s">%s</code></pre>`,
+			preAttrs, lang, highlighted.String(),
+		)
+	})
+}
+
+// Generates the stylesheet that goes with highlightCode's output.
+func highlightCSS(style *chroma.Style) []byte {
+	var buf bytes.Buffer
+	err := highlightFormatter.WriteCSS(&buf, style)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
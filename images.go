@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	"go.imnhan.com/s4g/errs"
+	"go.imnhan.com/s4g/writablefs"
+)
+
+var imgTagRegex = regexp.MustCompile(`<img [^>]*>`)
+var htmlAttrRegex = regexp.MustCompile(`([a-zA-Z-]+)="([^"]*)"`)
+
+// Variants generated by the last build, for the watcher to ignore. Those
+// generated by the build before are kept too, because they're deleted during
+// the last build if no longer needed.
+var generatedVariants = struct {
+	current  map[string]bool
+	previous map[string]bool
+	mut      sync.Mutex
+}{current: make(map[string]bool)}
+
+type responsiveImage struct {
+	Width    int
+	Height   int
+	Variants []imageVariant // smallest first
+}
+
+type imageVariant struct {
+	// Relative to the website's folder
+	Path  string
+	Width int
+}
+
+// Whether p, relative to the website's folder, is a variant generated by s4g.
+func isImageVariant(p string) bool {
+	p = filepath.ToSlash(p)
+	generatedVariants.mut.Lock()
+	defer generatedVariants.mut.Unlock()
+	return generatedVariants.current[p] || generatedVariants.previous[p]
+}
+
+// Records a variant before it's written, so that the watcher never sees it
+// as a user's file.
+func addImageVariant(p string) {
+	generatedVariants.mut.Lock()
+	defer generatedVariants.mut.Unlock()
+	generatedVariants.current[p] = true
+}
+
+// Replaces the variants recorded by the previous build with paths.
+func setImageVariants(paths map[string]bool) {
+	generatedVariants.mut.Lock()
+	defer generatedVariants.mut.Unlock()
+	generatedVariants.previous = generatedVariants.current
+	generatedVariants.current = paths
+}
+
+// "photos/bill.jpg", 480 => "photos/bill-480w.jpg"
+func imageVariantPath(p string, width int) string {
+	ext := path.Ext(p)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(p, ext), width, ext)
+}
+
+// Adds intrinsic dimensions, lazy loading and srcset to every local image in
+// ContentHtml, generating resized variants when needed.
+//
+// The images map caches already processed images across articles, keyed by
+// their paths relative to the website's folder. Its value is nil for files
+// that aren't supported images.
+//
+// Files generated last time are listed in oldFiles, so that a user's file
+// that happens to be named like a variant is never overwritten.
+func (a *Article) ProcessImages(
+	site *SiteMetadata,
+	images map[string]*responsiveImage,
+	oldFiles map[string]bool,
+	warn func(*errs.UserErr),
+) error {
+	var firstErr error
+	content := imgTagRegex.ReplaceAllStringFunc(
+		string(a.ContentHtml),
+		func(tag string) string {
+			if firstErr != nil {
+				return tag
+			}
+			attrs := make(map[string]string)
+			for _, m := range htmlAttrRegex.FindAllStringSubmatch(tag, -1) {
+				attrs[m[1]] = html.UnescapeString(m[2])
+			}
+
+			src := attrs["src"]
+			filePath, fragment, ok, uerr := resolveInternalLink(a, site.Root, src)
+			// Broken links are reported by the link checker instead
+			if uerr != nil || !ok || fragment != "" || strings.Contains(src, "?") ||
+				!fileExists(a.Fs, filePath) {
+				return tag
+			}
+
+			img, cached := images[filePath]
+			if !cached {
+				var err error
+				var skipped []string
+				img, skipped, err = loadResponsiveImage(
+					a.Fs, filePath, site.ImageWidths, oldFiles,
+				)
+				for _, p := range skipped {
+					warn(&errs.UserErr{
+						File: a.Path,
+						Msg: fmt.Sprintf(
+							`Image "%s": %s already exists but wasn't generated by s4g, so it's left out of srcset`,
+							src, p,
+						),
+					})
+				}
+				if err != nil {
+					firstErr = &errs.UserErr{
+						File: a.Path,
+						Msg:  fmt.Sprintf(`Image "%s": %s`, src, err),
+					}
+					return tag
+				}
+				images[filePath] = img
+			}
+			if img == nil {
+				return tag
+			}
+
+			var extra strings.Builder
+			_, hasWidth := attrs["width"]
+			_, hasHeight := attrs["height"]
+			if !hasWidth && !hasHeight {
+				fmt.Fprintf(&extra, ` width="%d" height="%d"`, img.Width, img.Height)
+			}
+			if _, ok := attrs["loading"]; !ok {
+				extra.WriteString(` loading="lazy"`)
+			}
+			if _, ok := attrs["srcset"]; !ok && len(img.Variants) > 0 {
+				var srcset []string
+				for _, v := range img.Variants {
+					srcset = append(srcset, fmt.Sprintf(
+						"%s %dw", imageVariantPath(src, v.Width), v.Width,
+					))
+				}
+				srcset = append(srcset, fmt.Sprintf("%s %dw", src, img.Width))
+				displayWidth := img.Width
+				if w, err := strconv.Atoi(attrs["width"]); err == nil {
+					displayWidth = w
+				}
+				fmt.Fprintf(
+					&extra, ` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`,
+					html.EscapeString(strings.Join(srcset, ", ")),
+					displayWidth, displayWidth,
+				)
+			}
+			return strings.TrimSuffix(tag, ">") + extra.String() + ">"
+		},
+	)
+	if firstErr != nil {
+		return firstErr
+	}
+	a.ContentHtml = template.HTML(content)
+	return nil
+}
+
+// Reads image dimensions and makes sure there's a resized variant for each
+// width that is smaller than the original. Existing variants are only
+// regenerated when the original is newer.
+//
+// A variant path that holds a file not generated by s4g last time belongs
+// to the user, so it's skipped instead of overwritten, and returned in
+// skipped.
+//
+// Returns nil for files that aren't in a supported image format.
+func loadResponsiveImage(
+	fsys writablefs.FS, filePath string, widths []int, oldFiles map[string]bool,
+) (img *responsiveImage, skipped []string, err error) {
+	content, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img = &responsiveImage{Width: cfg.Width, Height: cfg.Height}
+
+	// Resizing animated gifs would drop all but the first frame
+	if format != "jpeg" && format != "png" {
+		return img, nil, nil
+	}
+
+	sortedWidths := append([]int(nil), widths...)
+	sort.Ints(sortedWidths)
+
+	var decoded image.Image
+	for _, w := range sortedWidths {
+		if w <= 0 || w >= cfg.Width ||
+			(len(img.Variants) > 0 && img.Variants[len(img.Variants)-1].Width == w) {
+			continue
+		}
+		variant := imageVariant{Path: imageVariantPath(filePath, w), Width: w}
+		if !oldFiles[variant.Path] && !isImageVariant(variant.Path) &&
+			fileExists(fsys, variant.Path) {
+			skipped = append(skipped, variant.Path)
+			continue
+		}
+		img.Variants = append(img.Variants, variant)
+
+		if isUpToDate(fsys, variant.Path, filePath) {
+			continue
+		}
+
+		if decoded == nil {
+			decoded, _, err = image.Decode(bytes.NewReader(content))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		h := max(1, cfg.Height*w/cfg.Width)
+		resized := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), decoded, decoded.Bounds(), draw.Over, nil)
+
+		var buf bytes.Buffer
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		addImageVariant(variant.Path)
+		err = fsys.WriteFile(variant.Path, buf.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Println("Generated", variant.Path)
+	}
+
+	return img, skipped, nil
+}
+
+// Whether generated file exists and is at least as new as its source.
+func isUpToDate(fsys fs.FS, generated string, source string) bool {
+	genStat, err := fs.Stat(fsys, generated)
+	if err != nil {
+		return false
+	}
+	srcStat, err := fs.Stat(fsys, source)
+	if err != nil {
+		return false
+	}
+	return !genStat.ModTime().Before(srcStat.ModTime())
+}
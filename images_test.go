@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/fs"
+	"reflect"
+	"testing"
+)
+
+func TestImageVariantPath(t *testing.T) {
+	tests := map[string]string{
+		"photos/bill.jpg": "photos/bill-480w.jpg",
+		"a.b/c.png":       "a.b/c-480w.png",
+		"noext":           "noext-480w",
+	}
+	for in, want := range tests {
+		if got := imageVariantPath(in, 480); got != want {
+			t.Errorf("imageVariantPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIsImageVariant(t *testing.T) {
+	t.Cleanup(func() {
+		setImageVariants(make(map[string]bool))
+		setImageVariants(make(map[string]bool))
+	})
+
+	setImageVariants(map[string]bool{"old-480w.jpg": true})
+	setImageVariants(map[string]bool{"a-480w.jpg": true})
+	addImageVariant("b-960w.jpg")
+
+	// Only what s4g generated counts, not everything that looks like it
+	for p, want := range map[string]bool{
+		"a-480w.jpg":    true,
+		"b-960w.jpg":    true,
+		"old-480w.jpg":  true,
+		"mine-480w.jpg": false,
+	} {
+		if got := isImageVariant(p); got != want {
+			t.Errorf("isImageVariant(%q) = %v, want %v", p, got, want)
+		}
+	}
+
+	// Variants from two builds ago are long gone
+	setImageVariants(map[string]bool{})
+	if isImageVariant("old-480w.jpg") {
+		t.Error("old-480w.jpg should no longer be a variant")
+	}
+	if !isImageVariant("a-480w.jpg") {
+		t.Error("a-480w.jpg should still be a variant")
+	}
+}
+
+func TestLoadResponsiveImageKeepsUserFiles(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 100, 50))); err != nil {
+		t.Fatal(err)
+	}
+	fsys := newTestFS(t, map[string]string{
+		"img/a.png":     buf.String(),
+		"img/a-40w.png": "user's own file",
+	})
+
+	img, skipped, err := loadResponsiveImage(fsys, "img/a.png", []int{80, 40, 200}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 100 || img.Height != 50 {
+		t.Errorf("size = %dx%d, want 100x50", img.Width, img.Height)
+	}
+	want := []imageVariant{{Path: "img/a-80w.png", Width: 80}}
+	if !reflect.DeepEqual(img.Variants, want) {
+		t.Errorf("variants = %v, want %v", img.Variants, want)
+	}
+	if !reflect.DeepEqual(skipped, []string{"img/a-40w.png"}) {
+		t.Errorf("skipped = %v", skipped)
+	}
+	content, _ := fs.ReadFile(fsys, "img/a-40w.png")
+	if string(content) != "user's own file" {
+		t.Error("user's file was overwritten")
+	}
+	if !fileExists(fsys, "img/a-80w.png") {
+		t.Error("img/a-80w.png wasn't generated")
+	}
+
+	// Generated last time, so it's ours to regenerate
+	img, skipped, err = loadResponsiveImage(
+		fsys, "img/a.png", []int{40}, map[string]bool{"img/a-40w.png": true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Variants) != 1 || len(skipped) != 0 {
+		t.Errorf("variants = %v, skipped = %v", img.Variants, skipped)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"go.imnhan.com/s4g/errs"
+)
+
+// A line that starts with this directive is replaced with the content of
+// another file. Paths are relative to the current file, or to the website's
+// folder if they start with a "/":
+//
+//	!include ../shared/disclaimer.dj
+//	!include /code/main.go:10-25
+//	!include /code/main.go:10-25 golang
+//
+// Djot files are included as-is, minus their metadata. Other files are
+// included as a code block, optionally limited to a line range, in the
+// language of the given name or else the file's extension.
+const includeDirective = "!include "
+
+type includer struct {
+	fsys fs.FS
+	out  bytes.Buffer
+	// For each output line, the line in the article's body it came from.
+	// Included lines map to their top-level include directive.
+	lineMap []int
+	// Every included file, relative to the website's folder.
+	deps []string
+}
+
+// Expands include directives in an article's body.
+func expandIncludes(fsys fs.FS, a *Article) (
+	body []byte, lineMap []int, deps []string, uerr *errs.UserErr,
+) {
+	inc := includer{fsys: fsys}
+	uerr = inc.expand(a.Path, a.DjotBody, a.BodyLine-1, []string{a.Path}, 0)
+	if uerr != nil {
+		return nil, nil, nil, uerr
+	}
+	return inc.out.Bytes(), inc.lineMap, inc.deps, nil
+}
+
+// Writes body of filePath into output, expanding include directives.
+// Line numbers in errors are offset by lineOffset, which accounts for
+// metadata. If directiveLine isn't zero, every output line is mapped to it.
+func (inc *includer) expand(
+	filePath string, body []byte, lineOffset int, stack []string, directiveLine int,
+) *errs.UserErr {
+	var fence string
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		srcLine := directiveLine
+		if srcLine == 0 {
+			srcLine = i + 1
+		}
+
+		// Directives in code blocks are just text
+		trimmed := strings.TrimRight(line, "\r\n")
+		if f := codeFence(trimmed); f != "" {
+			if fence == "" {
+				fence = f
+			} else if strings.HasPrefix(f, fence) && strings.TrimSpace(trimmed) == f {
+				fence = ""
+			}
+		}
+
+		if fence != "" || !strings.HasPrefix(trimmed, includeDirective) {
+			inc.write(line, srcLine)
+			continue
+		}
+
+		uerr := inc.include(
+			filePath, strings.TrimSpace(trimmed[len(includeDirective):]),
+			stack, srcLine,
+		)
+		if uerr != nil {
+			if uerr.File == "" {
+				uerr.File = filePath
+				uerr.Line = lineOffset + i + 1
+			}
+			return uerr
+		}
+	}
+	return nil
+}
+
+func (inc *includer) include(
+	filePath string, args string, stack []string, srcLine int,
+) *errs.UserErr {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return &errs.UserErr{Msg: fmt.Sprintf(
+			`invalid include "%s", expected: %spath[:start-end] [language]`,
+			args, includeDirective,
+		)}
+	}
+
+	target, lineRange, hasRange := strings.Cut(fields[0], ":")
+	if strings.HasPrefix(target, "/") {
+		target = path.Clean(target[1:])
+	} else {
+		target = path.Join(path.Dir(filePath), target)
+	}
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return &errs.UserErr{Msg: fmt.Sprintf(
+			`cannot include "%s": file is outside of website folder`, fields[0],
+		)}
+	}
+
+	for _, p := range stack {
+		if p == target {
+			return &errs.UserErr{Msg: fmt.Sprintf(
+				"include cycle: %s", strings.Join(append(stack, target), " -> "),
+			)}
+		}
+	}
+
+	content, err := fs.ReadFile(inc.fsys, target)
+	if err != nil {
+		return &errs.UserErr{Msg: fmt.Sprintf(`cannot include "%s": %s`, fields[0], err)}
+	}
+	inc.deps = append(inc.deps, target)
+
+	if strings.HasSuffix(target, DjotExt) && !hasRange && len(fields) == 1 {
+		// Fragments without metadata are included as-is, because
+		// SeparateMetadata trims every line while looking for front matter.
+		body, lineOffset := content, 0
+		if hasFrontMatter(content) {
+			var meta []byte
+			meta, body = SeparateMetadata(bytes.NewReader(content))
+			lineOffset = bytes.Count(meta, []byte("\n")) + 1
+		}
+		return inc.expand(target, body, lineOffset, append(stack, target), srcLine)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	start, end := 1, len(lines)
+	if hasRange {
+		var ok bool
+		start, end, ok = parseLineRange(lineRange, len(lines))
+		if !ok {
+			return &errs.UserErr{Msg: fmt.Sprintf(
+				`invalid line range "%s" for %s, which has %d lines`,
+				lineRange, target, len(lines),
+			)}
+		}
+	}
+
+	lang := strings.TrimPrefix(path.Ext(target), ".")
+	if len(fields) == 2 {
+		lang = fields[1]
+	}
+	snippet := strings.Join(lines[start-1:end], "\n")
+
+	// Fence must be longer than any backtick run in the snippet
+	fence := "```"
+	for strings.Contains(snippet, fence) {
+		fence += "`"
+	}
+	inc.write(fence+" "+lang+"\n", srcLine)
+	for _, l := range lines[start-1 : end] {
+		inc.write(l+"\n", srcLine)
+	}
+	inc.write(fence+"\n", srcLine)
+	return nil
+}
+
+func (inc *includer) write(line string, srcLine int) {
+	inc.out.WriteString(line)
+	inc.lineMap = append(inc.lineMap, srcLine)
+}
+
+// Whether content has a front matter separator line.
+func hasFrontMatter(content []byte) bool {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.Equal(bytes.TrimSpace(line), frontMatterSep) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses "start-end", "start-" or "line", with 1-based inclusive bounds.
+func parseLineRange(s string, numLines int) (start, end int, ok bool) {
+	startStr, endStr, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = start
+	if isRange {
+		end = numLines
+		if endStr != "" {
+			end, err = strconv.Atoi(endStr)
+			if err != nil {
+				return 0, 0, false
+			}
+		}
+	}
+	if start < 1 || end > numLines || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// Returns the fence if line opens or closes a code block, e.g. "```".
+func codeFence(line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	for _, c := range []string{"`", "~"} {
+		n := len(trimmed) - len(strings.TrimLeft(trimmed, c))
+		if n >= 3 {
+			return trimmed[:n]
+		}
+	}
+	return ""
+}
+
+// Converts a line number in DjotBody, which may contain included content,
+// into a line number in the article's source file.
+func (a *Article) fileLine(bodyLine int) int {
+	if bodyLine > 0 && bodyLine <= len(a.bodyLineMap) {
+		bodyLine = a.bodyLineMap[bodyLine-1]
+	}
+	return a.BodyLine + bodyLine - 1
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		"shared/note.dj":  "Title: Note\n---\nNote body\n!include inner.dj\n",
+		"shared/inner.dj": "Inner\n\n- a\n\n  - nested\n\n      indented\n",
+		"code/main.go":    "package main\n\nfunc main() {\n\ts := \"```\"\n}\n",
+	})
+	a := &Article{
+		Path:     "posts/a.dj",
+		BodyLine: 3,
+		DjotBody: []byte("Intro\n" +
+			"!include ../shared/note.dj\n" +
+			"!include /code/main.go:3-4\n" +
+			"```\n" +
+			"!include not/expanded.dj\n" +
+			"```\n" +
+			"!include /code/main.go:1 golang\n"),
+	}
+
+	body, lineMap, deps, uerr := expandIncludes(fsys, a)
+	if uerr != nil {
+		t.Fatal(uerr)
+	}
+
+	wantBody := "Intro\n" +
+		"Note body\n" +
+		"Inner\n" +
+		"\n" +
+		"- a\n" +
+		"\n" +
+		"  - nested\n" +
+		"\n" +
+		"      indented\n" +
+		"```` go\n" +
+		"func main() {\n" +
+		"\ts := \"```\"\n" +
+		"````\n" +
+		"```\n" +
+		"!include not/expanded.dj\n" +
+		"```\n" +
+		"``` golang\n" +
+		"package main\n" +
+		"```\n"
+	if string(body) != wantBody {
+		t.Errorf("body:\n%s\nwant:\n%s", body, wantBody)
+	}
+
+	// Included lines map to their top-level directive
+	wantLineMap := []int{1, 2, 2, 2, 2, 2, 2, 2, 2, 3, 3, 3, 3, 4, 5, 6, 7, 7, 7}
+	if !reflect.DeepEqual(lineMap, wantLineMap) {
+		t.Errorf("lineMap = %v, want %v", lineMap, wantLineMap)
+	}
+
+	wantDeps := []string{"shared/note.dj", "shared/inner.dj", "code/main.go", "code/main.go"}
+	if !reflect.DeepEqual(deps, wantDeps) {
+		t.Errorf("deps = %v, want %v", deps, wantDeps)
+	}
+
+	a.bodyLineMap = lineMap
+	if got := a.fileLine(12); got != 5 {
+		t.Errorf("fileLine(12) = %d, want 5", got)
+	}
+}
+
+func TestExpandIncludesErrors(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		"cycle/a.dj": "Title: A\n---\n!include b.dj\n",
+		"cycle/b.dj": "one\n!include a.dj\n",
+		"code.txt":   "1\n2\n3\n",
+	})
+	tests := []struct {
+		body     string
+		wantFile string
+		wantLine int
+		wantMsg  string
+	}{
+		{
+			body:     "text\n!include cycle/a.dj\n",
+			wantFile: "cycle/b.dj",
+			wantLine: 2,
+			wantMsg:  "include cycle: article.dj -> cycle/a.dj -> cycle/b.dj -> cycle/a.dj",
+		},
+		{
+			body:     "!include ../outside.dj\n",
+			wantFile: "article.dj",
+			wantLine: 10,
+			wantMsg:  "outside of website folder",
+		},
+		{
+			body:     "!include missing.dj\n",
+			wantFile: "article.dj",
+			wantLine: 10,
+			wantMsg:  `cannot include "missing.dj"`,
+		},
+		{
+			body:     "!include code.txt:2-5\n",
+			wantFile: "article.dj",
+			wantLine: 10,
+			wantMsg:  `invalid line range "2-5" for code.txt, which has 3 lines`,
+		},
+		{
+			body:     "!include code.txt text extra\n",
+			wantFile: "article.dj",
+			wantLine: 10,
+			wantMsg:  "invalid include",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			a := &Article{Path: "article.dj", BodyLine: 10, DjotBody: []byte(tt.body)}
+			_, _, _, uerr := expandIncludes(fsys, a)
+			if uerr == nil {
+				t.Fatal("expected error")
+			}
+			if uerr.File != tt.wantFile || uerr.Line != tt.wantLine ||
+				!strings.Contains(uerr.Msg, tt.wantMsg) {
+				t.Errorf("got %s line %d: %s\nwant %s line %d: %s",
+					uerr.File, uerr.Line, uerr.Msg, tt.wantFile, tt.wantLine, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		ok         bool
+	}{
+		{"3", 3, 3, true},
+		{"2-4", 2, 4, true},
+		{"2-", 2, 10, true},
+		{"1-10", 1, 10, true},
+		{"0-2", 0, 0, false},
+		{"4-2", 0, 0, false},
+		{"5-11", 0, 0, false},
+		{"11", 0, 0, false},
+		{"-3", 0, 0, false},
+		{"a-b", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := parseLineRange(tt.s, 10)
+		if ok != tt.ok || (ok && (start != tt.start || end != tt.end)) {
+			t.Errorf("parseLineRange(%q) = %d, %d, %v, want %d, %d, %v",
+				tt.s, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestCodeFence(t *testing.T) {
+	tests := map[string]string{
+		"```":        "```",
+		"```` go":    "````",
+		"  ~~~ text": "~~~",
+		"``":         "",
+		"text ```":   "",
+		"":           "",
+	}
+	for line, want := range tests {
+		if got := codeFence(line); got != want {
+			t.Errorf("codeFence(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"go.imnhan.com/s4g/errs"
+	"go.imnhan.com/s4g/writablefs"
+)
+
+// What we care about in a generated html page.
+type parsedPage struct {
+	// Every href/src/srcset url, in order of appearance.
+	Links []string
+	// Every element id, which includes djot's heading ids.
+	Ids map[string]bool
+}
+
+func parsePage(content []byte) *parsedPage {
+	page := parsedPage{Ids: make(map[string]bool)}
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return &page
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		for _, attr := range z.Token().Attr {
+			switch attr.Key {
+			case "id":
+				page.Ids[attr.Val] = true
+			case "href", "src":
+				page.Links = append(page.Links, attr.Val)
+			case "srcset":
+				for _, candidate := range strings.Split(attr.Val, ",") {
+					fields := strings.Fields(candidate)
+					if len(fields) > 0 {
+						page.Links = append(page.Links, fields[0])
+					}
+				}
+			}
+		}
+	}
+}
+
+// Parses every generated article page.
+// Returns map of article path to its parsed page.
+func parseArticlePages(
+	fsys writablefs.FS, articles map[string]*Article,
+) map[string]*parsedPage {
+	pages := make(map[string]*parsedPage)
+	for _, a := range articles {
+		content, err := fs.ReadFile(fsys, a.OutputPath)
+		if err != nil {
+			panic(err)
+		}
+		pages[a.Path] = parsePage(content)
+	}
+	return pages
+}
+
+// Resolves link found in article a into a file path relative to the
+// website's folder, along with its fragment. Returns ok=false for links to
+// other websites or non-http schemes, which we don't check.
+//
+// File paths may point to a folder, in which case they end with a "/" (or
+// are empty for the website's root folder).
+func resolveInternalLink(
+	a *Article, root string, link string,
+) (filePath string, fragment string, ok bool, uerr *errs.UserErr) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", false, &errs.UserErr{
+			File: a.Path,
+			Msg:  fmt.Sprintf(`Invalid link "%s": %s`, link, err),
+		}
+	}
+	if u.Scheme != "" || u.Host != "" || link == "" {
+		return "", "", false, nil
+	}
+
+	base := &url.URL{Path: root + a.OutputPath}
+	resolved := base.ResolveReference(u)
+
+	if !strings.HasPrefix(resolved.Path, root) {
+		return "", "", false, &errs.UserErr{
+			File: a.Path,
+			Msg: fmt.Sprintf(
+				`Broken link "%s": points outside of website root "%s"`,
+				link, root,
+			),
+		}
+	}
+
+	return strings.TrimPrefix(resolved.Path, root), u.Fragment, true, nil
+}
+
+// Checks every link in every generated article, making sure it points to a
+// generated file or a static file, and that its fragment (if any) points to
+// an existing id when the target is an article.
+//
+// Links to a redirect source are also reported, along with the redirect's
+// final destination, so that readers don't have to go through the redirect.
+func checkLinks(
+	fsys writablefs.FS,
+	site *SiteMetadata,
+	articles map[string]*Article,
+	pages map[string]*parsedPage,
+	generatedFiles map[string]bool,
+	redirects []Redirect,
+) (problems []*errs.UserErr) {
+	pagesByOutput := make(map[string]*parsedPage)
+	for _, a := range articles {
+		pagesByOutput[a.OutputPath] = pages[a.Path]
+	}
+
+	redirectsBySource := make(map[string]Redirect)
+	for _, r := range redirects {
+		redirectsBySource[r.Src] = r
+	}
+
+	for _, a := range sortedArticles(articles) {
+		seen := make(map[string]bool)
+		for _, link := range pages[a.Path].Links {
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+
+			filePath, fragment, ok, uerr := resolveInternalLink(a, site.Root, link)
+			if uerr != nil {
+				problems = append(problems, uerr)
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			// Redirect sources are looked up first, since with host-native
+			// redirect formats they don't exist as files.
+			targetPath := filePath
+			if targetPath == "" || strings.HasSuffix(targetPath, "/") {
+				targetPath += "index.html"
+			}
+
+			if r, ok := redirectsBySource[targetPath]; ok {
+				dest := r.webDest(site.Root)
+				if fragment != "" && !strings.Contains(dest, "#") {
+					dest += "#" + fragment
+				}
+				problems = append(problems, &errs.UserErr{
+					File: a.Path,
+					Msg: fmt.Sprintf(
+						`Link "%s" points to a redirect (%s), use "%s" instead`,
+						link, r.origin(), dest,
+					),
+				})
+				continue
+			}
+
+			if !redirectDestExists(fsys, filePath, nil, generatedFiles) {
+				problems = append(problems, &errs.UserErr{
+					File: a.Path,
+					Msg:  fmt.Sprintf(`Broken link "%s": file not found`, link),
+				})
+				continue
+			}
+
+			if fragment == "" {
+				continue
+			}
+			target, isArticle := pagesByOutput[targetPath]
+			if isArticle && !target.Ids[fragment] {
+				problems = append(problems, &errs.UserErr{
+					File: a.Path,
+					Msg: fmt.Sprintf(
+						`Broken link "%s": no heading or element with id "%s"`,
+						link, fragment,
+					),
+				})
+			}
+		}
+	}
+
+	return problems
+}
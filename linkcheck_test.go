@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	page := parsePage([]byte(`<html><body>
+<h2 id="intro">Intro</h2>
+<a href="a.html">a</a><a href="#intro">self</a>
+<img src="cat.jpg" srcset="cat-480w.jpg 480w, cat-960w.jpg 960w" />
+<p id="note">Text</p>
+</body></html>`))
+
+	wantLinks := []string{"a.html", "#intro", "cat.jpg", "cat-480w.jpg", "cat-960w.jpg"}
+	if !reflect.DeepEqual(page.Links, wantLinks) {
+		t.Errorf("links = %v, want %v", page.Links, wantLinks)
+	}
+	wantIds := map[string]bool{"intro": true, "note": true}
+	if !reflect.DeepEqual(page.Ids, wantIds) {
+		t.Errorf("ids = %v, want %v", page.Ids, wantIds)
+	}
+}
+
+func TestResolveInternalLink(t *testing.T) {
+	a := &Article{Path: "posts/a.dj", OutputPath: "posts/a.html"}
+	tests := []struct {
+		link     string
+		filePath string
+		fragment string
+		ok       bool
+		wantErr  bool
+	}{
+		{link: "b.html", filePath: "posts/b.html", ok: true},
+		{link: "../about/", filePath: "about/", ok: true},
+		{link: "/blog/", filePath: "", ok: true},
+		{link: "/blog/x.html#top", filePath: "x.html", fragment: "top", ok: true},
+		{link: "#top", filePath: "posts/a.html", fragment: "top", ok: true},
+		{link: "my%20file.html", filePath: "posts/my file.html", ok: true},
+		{link: "https://example.com/"},
+		{link: "//example.com/"},
+		{link: "mailto:me@example.com"},
+		{link: ""},
+		{link: "/elsewhere.html", wantErr: true},
+		{link: "../../outside.html", wantErr: true},
+		{link: "%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.link, func(t *testing.T) {
+			filePath, fragment, ok, uerr := resolveInternalLink(a, "/blog/", tt.link)
+			if (uerr != nil) != tt.wantErr {
+				t.Fatalf("uerr = %v, wantErr %v", uerr, tt.wantErr)
+			}
+			if filePath != tt.filePath || fragment != tt.fragment || ok != tt.ok {
+				t.Errorf("got (%q, %q, %v), want (%q, %q, %v)",
+					filePath, fragment, ok, tt.filePath, tt.fragment, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		"static/cat.jpg":  "",
+		"docs/index.html": "",
+		"old.html":        "",
+		"stale.html":      "",
+		"posts/b.html":    "",
+		"posts/a.html":    "",
+	})
+	articles := map[string]*Article{
+		"posts/a.dj": {Path: "posts/a.dj", OutputPath: "posts/a.html"},
+		"posts/b.dj": {Path: "posts/b.dj", OutputPath: "posts/b.html"},
+	}
+	pages := map[string]*parsedPage{
+		"posts/a.dj": {
+			Links: []string{
+				"b.html", "b.html#section", "b.html#missing",
+				"/static/cat.jpg", "/docs", "/docs/#anything",
+				"/old.html#x", "/gone/", "/nope.html", "/nope.html",
+				"https://example.com/",
+			},
+			Ids: map[string]bool{},
+		},
+		"posts/b.dj": {
+			Links: []string{"a.html#top"},
+			Ids:   map[string]bool{"section": true},
+		},
+	}
+	generatedFiles := map[string]bool{
+		"posts/a.html": true,
+		"posts/b.html": true,
+		"old.html":     true,
+	}
+	redirects := []Redirect{
+		{Src: "old.html", Dest: "posts/b.html", File: RedirectsPath, Line: 3},
+		// Host-native formats don't generate a file for the source
+		{Src: "gone/index.html", Dest: "posts/b.html", File: "posts/b.dj", Field: "Aliases"},
+	}
+
+	problems := checkLinks(
+		fsys, &SiteMetadata{Root: "/"}, articles, pages, generatedFiles, redirects,
+	)
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.File+": "+p.Msg)
+	}
+	want := []string{
+		`posts/a.dj: Broken link "b.html#missing": no heading or element with id "missing"`,
+		`posts/a.dj: Link "/old.html#x" points to a redirect (` + RedirectsPath +
+			` line 3), use "/posts/b.html#x" instead`,
+		`posts/a.dj: Link "/gone/" points to a redirect (posts/b.dj (Aliases)), use "/posts/b.html" instead`,
+		`posts/a.dj: Broken link "/nope.html": file not found`,
+		`posts/b.dj: Broken link "a.html#top": no heading or element with id "top"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Populates every article's OutgoingLinks and Backlinks by analyzing links
+// in their rendered content. Links in templates (e.g. navbar) are ignored
+// because they'd make every article link to the same few pages.
+func computeLinkGraph(articles map[string]*Article, root string) {
+	byOutput := make(map[string]*Article)
+	for _, a := range articles {
+		byOutput[a.OutputPath] = a
+		a.OutgoingLinks = nil
+		a.Backlinks = nil
+	}
+
+	for _, a := range sortedArticles(articles) {
+		seen := make(map[*Article]bool)
+		for _, link := range parsePage([]byte(a.ContentHtml)).Links {
+			filePath, _, ok, uerr := resolveInternalLink(a, root, link)
+			if uerr != nil || !ok {
+				continue
+			}
+			if filePath == "" || strings.HasSuffix(filePath, "/") {
+				filePath += "index.html"
+			}
+
+			target, ok := byOutput[filePath]
+			if !ok || target == a || seen[target] {
+				continue
+			}
+			seen[target] = true
+
+			a.OutgoingLinks = append(a.OutgoingLinks, target)
+			target.Backlinks = append(target.Backlinks, a)
+		}
+	}
+}
+
+// Articles sorted by path, for deterministic output.
+func sortedArticles(articles map[string]*Article) []*Article {
+	result := make([]*Article, 0, len(articles))
+	for _, a := range articles {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+type linkGraphNode struct {
+	Id          string `json:"id"`
+	Title       string `json:"title"`
+	Url         string `json:"url"`
+	WordCount   int    `json:"wordCount"`
+	ReadingTime int    `json:"readingTime"`
+}
+
+type linkGraphLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Nodes are identified by their article's source path. This shape can be
+// fed to most graph visualization libraries (e.g. d3-force) as-is.
+type linkGraph struct {
+	Nodes []linkGraphNode `json:"nodes"`
+	Links []linkGraphLink `json:"links"`
+}
+
+// Must run computeLinkGraph() first.
+func generateLinkGraph(articles map[string]*Article) []byte {
+	graph := linkGraph{
+		Nodes: []linkGraphNode{},
+		Links: []linkGraphLink{},
+	}
+	for _, a := range sortedArticles(articles) {
+		if a.IsDraft {
+			continue
+		}
+		graph.Nodes = append(graph.Nodes, linkGraphNode{
+			Id:          a.Path,
+			Title:       a.Title,
+			Url:         a.WebPath,
+			WordCount:   a.WordCount,
+			ReadingTime: a.ReadingTime,
+		})
+		for _, target := range a.OutgoingLinks {
+			if target.IsDraft {
+				continue
+			}
+			graph.Links = append(graph.Links, linkGraphLink{
+				Source: a.Path,
+				Target: target.Path,
+			})
+		}
+	}
+
+	result, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
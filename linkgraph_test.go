@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"reflect"
+	"testing"
+)
+
+func TestComputeLinkGraph(t *testing.T) {
+	newArticle := func(path, outputPath, content string) *Article {
+		return &Article{Path: path, OutputPath: outputPath, ContentHtml: template.HTML(content)}
+	}
+	articles := map[string]*Article{
+		"a.dj": newArticle("a.dj", "a.html", `
+			<a href="/blog/b.html">b</a>
+			<a href="b.html#section">b again</a>
+			<a href="a.html#self">self</a>
+			<a href="#top">fragment only</a>
+			<a href="posts/">folder</a>
+			<a href="https://example.com/b.html">external</a>
+			<a href="missing.html">missing</a>
+			<a href="../../outside.html">outside</a>
+			<a href="/blog/c/">c</a>`),
+		"b.dj":            newArticle("b.dj", "b.html", `<a href="a.html">a</a>`),
+		"posts/index.dj":  newArticle("posts/index.dj", "posts/index.html", `<a href="../c/">c</a>`),
+		"c/index.dj":      newArticle("c/index.dj", "c/index.html", `no links`),
+		"lonely/index.dj": newArticle("lonely/index.dj", "lonely/index.html", ``),
+	}
+
+	want := map[string]struct{ outgoing, backlinks []string }{
+		"a.dj":            {[]string{"b.dj", "posts/index.dj", "c/index.dj"}, []string{"b.dj"}},
+		"b.dj":            {[]string{"a.dj"}, []string{"a.dj"}},
+		"posts/index.dj":  {[]string{"c/index.dj"}, []string{"a.dj"}},
+		"c/index.dj":      {nil, []string{"a.dj", "posts/index.dj"}},
+		"lonely/index.dj": {nil, nil},
+	}
+
+	// Recomputing, e.g. on every regeneration, mustn't accumulate links
+	for i := 0; i < 2; i++ {
+		computeLinkGraph(articles, "/blog/")
+	}
+	for path, w := range want {
+		a := articles[path]
+		if got := articlePaths(a.OutgoingLinks); !reflect.DeepEqual(got, w.outgoing) {
+			t.Errorf("%s outgoing links = %v, want %v", path, got, w.outgoing)
+		}
+		if got := articlePaths(a.Backlinks); !reflect.DeepEqual(got, w.backlinks) {
+			t.Errorf("%s backlinks = %v, want %v", path, got, w.backlinks)
+		}
+	}
+}
+
+func TestGenerateLinkGraphSkipsDrafts(t *testing.T) {
+	a := &Article{Path: "a.dj", WebPath: "/a.html"}
+	b := &Article{Path: "b.dj", WebPath: "/b.html"}
+	draft := &Article{Path: "draft.dj", WebPath: "/draft.html"}
+	draft.IsDraft = true
+	a.OutgoingLinks = []*Article{b, draft}
+	draft.OutgoingLinks = []*Article{a}
+
+	var graph linkGraph
+	err := json.Unmarshal(
+		generateLinkGraph(map[string]*Article{"a.dj": a, "b.dj": b, "draft.dj": draft}),
+		&graph,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []string
+	for _, n := range graph.Nodes {
+		nodes = append(nodes, n.Id)
+	}
+	if !reflect.DeepEqual(nodes, []string{"a.dj", "b.dj"}) {
+		t.Errorf("nodes = %v", nodes)
+	}
+	wantLinks := []linkGraphLink{{Source: "a.dj", Target: "b.dj"}}
+	if !reflect.DeepEqual(graph.Links, wantLinks) {
+		t.Errorf("links = %v, want %v", graph.Links, wantLinks)
+	}
+}
+
+func articlePaths(articles []*Article) []string {
+	var paths []string
+	for _, a := range articles {
+		paths = append(paths, a.Path)
+	}
+	return paths
+}
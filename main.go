@@ -3,20 +3,22 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/alecthomas/chroma/v2"
 	"go.imnhan.com/s4g/djot"
 	"go.imnhan.com/s4g/errs"
 	"go.imnhan.com/s4g/livereload"
@@ -25,6 +27,7 @@ import (
 
 const DjotExt = ".dj"
 const FeedPath = "feed.xml"
+const LinkGraphPath = "links.json"
 const S4gDir = "_s4g"
 
 var SettingsPath = S4gDir + "/settings.txt"
@@ -34,7 +37,7 @@ var ThemePath = S4gDir + "/theme"
 
 func main() {
 	invalidCommand := func() {
-		fmt.Println("Usage: s4g new|serve [...]")
+		fmt.Println("Usage: s4g new|serve|build|theme [...]")
 		os.Exit(1)
 	}
 
@@ -59,6 +62,24 @@ func main() {
 	serveCmd.StringVar(&serveHost, "h", "127.0.0.1", "Local server host")
 	serveCmd.StringVar(&servePort, "p", "8000", "Local server port")
 
+	var buildFolder string
+	var buildStrict bool
+	buildCmd := flag.NewFlagSet("build", flag.ExitOnError)
+	buildCmd.StringVar(&buildFolder, "f", ".", "Website's root folder")
+	buildCmd.BoolVar(
+		&buildStrict, "strict", false,
+		"Treat warnings such as broken links as errors",
+	)
+
+	var themeFolder string
+	var themeUpgrade bool
+	themeCmd := flag.NewFlagSet("theme", flag.ExitOnError)
+	themeCmd.StringVar(&themeFolder, "f", ".", "Website's root folder")
+	themeCmd.BoolVar(
+		&themeUpgrade, "upgrade", false,
+		"Merge changes from the built-in theme into the website's theme",
+	)
+
 	switch cmd {
 	case "new":
 		newCmd.Parse(args)
@@ -66,6 +87,12 @@ func main() {
 	case "serve":
 		serveCmd.Parse(args)
 		handleServeCmd(serveFolder, serveHost+":"+servePort)
+	case "build":
+		buildCmd.Parse(args)
+		handleBuildCmd(buildFolder, buildStrict)
+	case "theme":
+		themeCmd.Parse(args)
+		handleThemeCmd(themeFolder, themeUpgrade)
 	default:
 		invalidCommand()
 	}
@@ -79,7 +106,8 @@ func handleNewCmd(folder string) {
 	}
 }
 
-func handleServeCmd(folder, addr string) {
+// Exits early if folder isn't an s4g website.
+func openSiteFolder(folder string) writablefs.FS {
 	absolutePath, err := filepath.Abs(folder)
 	if err != nil {
 		panic(err)
@@ -94,10 +122,28 @@ func handleServeCmd(folder, addr string) {
 		os.Exit(1)
 	}
 
+	return writablefs.WriteDirFS(absolutePath)
+}
+
+func handleBuildCmd(folder string, strict bool) {
+	fsys := openSiteFolder(folder)
+
+	djot.StartService()
+	fmt.Println("Started djot.js service")
+
+	_, err := regenerate(fsys, strict)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func handleServeCmd(folder, addr string) {
+	fsys := openSiteFolder(folder)
+
 	djot.StartService()
 	fmt.Println("Started djot.js service")
 
-	fsys := writablefs.WriteDirFS(absolutePath)
 	site, err := ReadSiteMetadata(fsys)
 	if err != nil {
 		panic(err)
@@ -136,7 +182,7 @@ func handleServeCmd(folder, addr string) {
 	// directory.
 	closeWatcher := WatchLocalFS(fsys, func() {
 		fmt.Println("Change detected. Regenerating...")
-		newSite, err := regenerate(fsys)
+		newSite, err := regenerate(fsys, false)
 		livereload.SetError(err)
 		if err == nil {
 			webRootUpdates <- newSite.Root
@@ -144,7 +190,7 @@ func handleServeCmd(folder, addr string) {
 	})
 	defer closeWatcher()
 
-	_, err = regenerate(fsys)
+	_, err = regenerate(fsys, false)
 	livereload.SetError(err)
 
 	wg.Wait()
@@ -156,11 +202,13 @@ func runServer(fsys writablefs.FS, webRoot, addr string) *http.Server {
 	mux := http.NewServeMux()
 	mux.Handle(
 		webRoot,
-		livereload.Middleware(
-			mux,
-			webRoot,
-			fsys,
-			http.StripPrefix(webRoot, http.FileServer(http.FS(fsys))),
+		devRedirectMiddleware(
+			livereload.Middleware(
+				mux,
+				webRoot,
+				fsys,
+				http.StripPrefix(webRoot, http.FileServer(http.FS(fsys))),
+			),
 		),
 	)
 
@@ -183,6 +231,45 @@ func runServer(fsys writablefs.FS, webRoot, addr string) *http.Server {
 	return srv
 }
 
+// Maps web paths to their redirect destinations so the local server can
+// respond with real 301s just like a properly configured host would.
+// Updated on every regeneration.
+var devRedirects = struct {
+	m    map[string]*Redirect
+	root string
+	mut  sync.RWMutex
+}{}
+
+func setDevRedirects(root string, redirects []Redirect) {
+	m := make(map[string]*Redirect)
+	for i, r := range redirects {
+		for _, src := range r.webSources(root) {
+			m[src] = &redirects[i]
+		}
+	}
+	devRedirects.mut.Lock()
+	devRedirects.m = m
+	devRedirects.root = root
+	devRedirects.mut.Unlock()
+}
+
+func devRedirectMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		devRedirects.mut.RLock()
+		redirect, ok := devRedirects.m[r.URL.Path]
+		root := devRedirects.root
+		devRedirects.mut.RUnlock()
+		if ok {
+			// Browsers cache 301s forever by default, which is not what
+			// we want while redirects.txt is still being edited.
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, redirect.webDest(root), redirect.Status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 type Link struct {
 	Text   string
 	Url    string
@@ -191,8 +278,32 @@ type Link struct {
 
 const NewTabSuffix = "^"
 
-func regenerate(fsys writablefs.FS) (site *SiteMetadata, err error) {
+// Prints warnings as they come, or in strict mode collects them to be
+// returned as errors at the end of regenerate().
+type warningReporter struct {
+	strict   bool
+	warnings []error
+}
+
+func (w *warningReporter) warn(uerr *errs.UserErr) {
+	if w.strict {
+		w.warnings = append(w.warnings, uerr)
+		return
+	}
+	location := uerr.File
+	if uerr.Line != 0 {
+		location += fmt.Sprintf(" line %d", uerr.Line)
+	}
+	if uerr.Field != "" {
+		location += fmt.Sprintf(" (%s)", uerr.Field)
+	}
+	fmt.Println("WARN:", location+":", uerr.Msg)
+}
+
+// In strict mode, warnings such as broken links are returned as errors.
+func regenerate(fsys writablefs.FS, strict bool) (site *SiteMetadata, err error) {
 	defer timer("Took %s")()
+	warnings := &warningReporter{strict: strict}
 
 	site, err = ReadSiteMetadata(fsys)
 	if err != nil {
@@ -205,6 +316,12 @@ func regenerate(fsys writablefs.FS) (site *SiteMetadata, err error) {
 		return nil, err
 	}
 
+	var includes []string
+	for _, a := range articles {
+		includes = append(includes, a.Includes...)
+	}
+	setWatchedDeps(includes)
+
 	if len(articles) == 0 {
 		fmt.Println("No articles found.")
 		fsys.RemoveAll(FeedPath)
@@ -212,6 +329,7 @@ func regenerate(fsys writablefs.FS) (site *SiteMetadata, err error) {
 	}
 
 	generatedFiles := make(map[string]bool)
+	fingerprints := make(map[string]string)
 
 	var navLinks []Link
 	// A NavbarLinks item can either be a path to a .dj file,
@@ -256,6 +374,11 @@ func regenerate(fsys writablefs.FS) (site *SiteMetadata, err error) {
 		return articlesInFeed[i].PostedAt.Compare(articlesInFeed[j].PostedAt) > 0
 	})
 
+	xrefs := make(map[string]djot.Xref)
+	for _, a := range articles {
+		xrefs[a.Path] = djot.Xref{Url: a.WebPath, Title: a.Title}
+	}
+
 	// TODO: fix wasteful loop?
 	for _, a := range articles {
 		// Sort articles in series, oldest first
@@ -266,36 +389,162 @@ func regenerate(fsys writablefs.FS) (site *SiteMetadata, err error) {
 		}
 	}
 
+	style, uerr := highlightStyle(site)
+	if uerr != nil {
+		return nil, uerr
+	}
+	oldFiles, oldRedirectSources := readManifest(fsys)
+
+	// highlight.css used to be generated into the theme folder, where a stale
+	// copy would take precedence over the one generated now.
+	if p := ThemePath + "/highlight.css"; oldFiles[p] {
+		fsys.RemoveAll(p)
+	}
+	if style != nil && !fileExists(fsys, ThemePath+"/highlight.css") {
+		fsys.MkdirAll(DefaultThemePath)
+		fsys.WriteFile(HighlightCSSPath, highlightCSS(style))
+		generatedFiles[HighlightCSSPath] = true
+	}
+
+	err = writeDefaultThemeAssets(fsys, generatedFiles)
+	if err != nil {
+		return nil, fmt.Errorf("write default theme assets: %w", err)
+	}
+
+	filters, uerr := readDjotFilters(fsys, site)
+	if uerr != nil {
+		return nil, uerr
+	}
+	headingLinks, uerr := headingLinkOptions(site)
+	if uerr != nil {
+		return nil, uerr
+	}
+	djotOpts := djot.Options{
+		Xrefs:        xrefs,
+		Filters:      filters,
+		HeadingLinks: headingLinks,
+	}
+
+	shortcodes := newShortcodeSet(themeFS{fsys}, templateFuncs(site, fsys))
+
+	for _, a := range articles {
+		err := a.RenderContent(djotOpts, style, shortcodes)
+		if err != nil {
+			return nil, fmt.Errorf("Article %s: %w", a.Path, err)
+		}
+	}
+
+	images := make(map[string]*responsiveImage)
+	for _, a := range articles {
+		err := a.ProcessImages(site, images, oldFiles, warnings.warn)
+		if err != nil {
+			return nil, fmt.Errorf("Article %s: %w", a.Path, err)
+		}
+	}
+	variants := make(map[string]bool)
+	for _, img := range images {
+		if img == nil {
+			continue
+		}
+		for _, v := range img.Variants {
+			generatedFiles[v.Path] = true
+			variants[v.Path] = true
+		}
+	}
+	setImageVariants(variants)
+
+	for _, a := range articles {
+		a.ComputeSummary(site.Root, site.SummaryWords)
+		a.WordCount = countWords(string(a.ContentHtml))
+		a.ReadingTime = readingTime(a.WordCount, site.WordsPerMinute)
+	}
+
+	computeLinkGraph(articles, site.Root)
+	if site.GenerateLinkGraph {
+		fsys.WriteFile(LinkGraphPath, generateLinkGraph(articles))
+		generatedFiles[LinkGraphPath] = true
+		fmt.Println("Generated", LinkGraphPath)
+	}
+
+	if site.GenerateOGCards {
+		err := generateOGCards(
+			fsys, site, articles, generatedFiles, fingerprints, warnings.warn,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, a := range articles {
 		err := a.WriteHtmlFile(site, navLinks, articlesInFeed, startYear)
 		if err != nil {
 			return nil, fmt.Errorf("Article %s: %w", a.Path, err)
 		}
 		generatedFiles[a.OutputPath] = true
+		fingerprints[a.OutputPath] = articleFingerprint(a)
 	}
 	fmt.Printf("Processed %d articles\n", len(articles))
 
 	if len(articlesInFeed) > 0 {
-		fsys.WriteFile(
-			FeedPath,
-			generateFeed(site, articlesInFeed, site.Root+FeedPath),
-		)
+		feed := generateFeed(site, articlesInFeed, site.Root+FeedPath)
+		fsys.WriteFile(FeedPath, feed)
 		generatedFiles[FeedPath] = true
 		fmt.Println("Generated", FeedPath)
+		for _, w := range validateFeed(feed) {
+			warnings.warn(&errs.UserErr{File: FeedPath, Msg: w})
+		}
 	}
 
-	redirects, uerr := generateRedirects(fsys, RedirectsPath, site.Root)
+	aliases, uerr := articleAliases(articles)
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	redirects, redirectFiles, redirectSources, uerr := generateRedirects(
+		fsys,
+		RedirectsPath,
+		site.Root,
+		site.RedirectFormats,
+		aliases,
+		oldFiles,
+		oldRedirectSources,
+		generatedFiles,
+		warnings.warn,
+	)
 	if uerr != nil {
 		return nil, fmt.Errorf("generate redirects: %w", uerr)
 	}
-	for _, p := range redirects {
+	for _, p := range redirectFiles {
 		generatedFiles[p] = true
 	}
+	setDevRedirects(site.Root, redirects)
 	fmt.Printf("Generated %d redirects\n", len(redirects))
 
-	DeleteOldGeneratedFiles(fsys, generatedFiles)
-	WriteManifest(fsys, generatedFiles)
+	redirected := make(map[string]bool)
+	for _, r := range redirects {
+		redirected[r.Src] = true
+	}
+	redirectsChanged := DeleteOldGeneratedFiles(
+		fsys, generatedFiles, fingerprints, redirected, site.AutoRedirectMoves,
+	)
+	WriteManifest(fsys, generatedFiles, fingerprints, redirectSources)
+
+	if redirectsChanged {
+		fmt.Println("Regenerating to apply new redirects...")
+		return regenerate(fsys, strict)
+	}
+
+	pages := parseArticlePages(fsys, articles)
+	linkProblems := checkLinks(
+		fsys, site, articles, pages, generatedFiles, redirects,
+	)
+	for _, p := range linkProblems {
+		warnings.warn(p)
+	}
 
+	if len(warnings.warnings) > 0 {
+		return nil, errors.Join(warnings.warnings...)
+	}
 	return
 }
 
@@ -304,24 +553,55 @@ type Article struct {
 	Path       string
 	OutputPath string
 	DjotBody   []byte
+	// Line number where DjotBody starts in the source file
+	BodyLine int
+	// Maps each line of DjotBody to its line in the source file's body,
+	// which differ once includes are expanded. See fileLine().
+	bodyLineMap []int
+	// Files included into DjotBody
+	Includes []string
 	ArticleMetadata
 	WebPath        string
 	TemplatePaths  []string
 	OpenGraphImage string
-	Parent         *Article
-	Children       []*Article
+	// Whether OpenGraphImage is a generated 1200x630 card
+	HasOGCard bool
+	Parent    *Article
+	Children  []*Article
+
+	ContentHtml template.HTML
+	// Leading part of ContentHtml, with links relative to the website's root
+	Summary template.HTML
+	TOC     []djot.TOCEntry
+	// Words in ContentHtml, and estimated minutes to read them
+	WordCount   int
+	ReadingTime int
+	// Other articles that this one links to, and vice versa
+	OutgoingLinks []*Article
+	Backlinks     []*Article
 }
 
 func (a *Article) IsSeriesIndex() bool {
 	return a.PageType == PTSeriesIndex
 }
 
-func (a *Article) ComputeDerivedFields(addr, root string) {
-	a.computeWebPath(root)
+func (a *Article) ComputeDerivedFields(site *SiteMetadata) {
+	a.computeWebPath(site.Root)
 	a.computeTemplatePaths()
 
 	if a.Thumb != "" {
-		a.OpenGraphImage = addr + root + filepath.Dir(a.Path) + "/" + a.Thumb
+		a.OpenGraphImage = site.Address + site.Root + filepath.Dir(a.Path) + "/" + a.Thumb
+	} else if site.GenerateOGCards {
+		a.OpenGraphImage = site.Address + site.Root + escapePath(ogCardPath(a))
+		a.HasOGCard = true
+	}
+
+	// Articles without their own author fall back to the site's author.
+	// Name and email are only inherited together, otherwise we'd end up
+	// mixing the site author's name or email with a guest author's.
+	if a.Author == "" && a.AuthorEmail == "" {
+		a.Author = site.AuthorName
+		a.AuthorEmail = site.AuthorEmail
 	}
 }
 
@@ -331,13 +611,7 @@ func (a *Article) computeWebPath(root string) {
 		webPath = strings.TrimSuffix(webPath, "index.html")
 	}
 
-	parts := strings.Split(webPath, "/")
-	escaped := make([]string, len(parts))
-	for i := 0; i < len(parts); i++ {
-		escaped[i] = url.PathEscape(parts[i])
-	}
-
-	a.WebPath = strings.Join(escaped, "/")
+	a.WebPath = escapePath(webPath)
 }
 
 func (a *Article) computeTemplatePaths() {
@@ -380,15 +654,83 @@ func (a *Article) computeTemplatePaths() {
 	a.TemplatePaths = paths
 }
 
+// Returns nil if heading links are disabled.
+func headingLinkOptions(site *SiteMetadata) (*djot.HeadingLinks, *errs.UserErr) {
+	if !site.HeadingLinks {
+		return nil, nil
+	}
+	if site.HeadingLinkPosition != "before" && site.HeadingLinkPosition != "after" {
+		return nil, &errs.UserErr{
+			File:  SettingsPath,
+			Field: "HeadingLinkPosition",
+			Msg: fmt.Sprintf(
+				`expected "before" or "after", got "%s"`, site.HeadingLinkPosition,
+			),
+		}
+	}
+	for _, level := range site.HeadingLinkLevels {
+		if level < 1 || level > 6 {
+			return nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "HeadingLinkLevels",
+				Msg:   fmt.Sprintf("invalid heading level %d, expected 1 to 6", level),
+			}
+		}
+	}
+	return &djot.HeadingLinks{
+		Symbol: string(site.HeadingLinkSymbol),
+		Before: site.HeadingLinkPosition == "before",
+		Class:  site.HeadingLinkClass,
+		Levels: site.HeadingLinkLevels,
+	}, nil
+}
+
+// Converts DjotBody into ContentHtml.
+// Options are shared by all articles, so Path is filled in here.
+// Code blocks are syntax highlighted unless style is nil.
+func (a *Article) RenderContent(
+	opts djot.Options, style *chroma.Style, shortcodes *shortcodeSet,
+) error {
+	opts.Path = a.Path
+	result, problems := djot.ToHtml(markSummaryBreak(a.DjotBody), opts)
+	if len(problems) > 0 {
+		p := problems[0]
+		if p.Filter != "" {
+			return &errs.UserErr{
+				File: FiltersPath + "/" + p.Filter,
+				Line: p.Line,
+				Msg:  p.Msg,
+			}
+		}
+		uerr := &errs.UserErr{File: a.Path, Msg: p.Msg}
+		if p.Line != 0 {
+			uerr.Line = a.fileLine(p.Line)
+		}
+		return uerr
+	}
+	contentHtml := result.Html
+	if style != nil {
+		contentHtml = highlightCode(contentHtml, style)
+	}
+	contentHtml, err := a.expandShortcodes(contentHtml, result.Shortcodes, shortcodes)
+	if err != nil {
+		return err
+	}
+	a.ContentHtml = template.HTML(contentHtml)
+	a.TOC = result.TOC
+	return nil
+}
+
+// Must run RenderContent() first.
 func (a *Article) WriteHtmlFile(
 	site *SiteMetadata,
 	navLinks []Link,
 	articlesInFeed []*Article,
 	startYear int,
 ) error {
-	contentHtml := djot.ToHtml(a.DjotBody)
-
-	tmpl, err := template.ParseFS(a.Fs, a.TemplatePaths...)
+	tmpl, err := template.New(path.Base(a.TemplatePaths[0])).
+		Funcs(templateFuncs(site, a.Fs)).
+		ParseFS(themeFS{a.Fs}, a.TemplatePaths...)
 	// TODO: should probably reuse the template object for common cases
 	if err != nil {
 		return fmt.Errorf(
@@ -400,6 +742,7 @@ func (a *Article) WriteHtmlFile(
 	err = tmpl.Execute(&buf, struct {
 		Site           *SiteMetadata
 		Content        template.HTML
+		TOC            []djot.TOCEntry
 		Title          string
 		Post           *Article
 		NavLinks       []Link
@@ -410,7 +753,8 @@ func (a *Article) WriteHtmlFile(
 		ThemePath      string
 	}{
 		Site:           site,
-		Content:        template.HTML(contentHtml),
+		Content:        a.ContentHtml,
+		TOC:            a.TOC,
 		Title:          a.Title,
 		Post:           a,
 		NavLinks:       navLinks,
@@ -437,6 +781,7 @@ func (a *Article) WriteHtmlFile(
 func findArticles(fsys writablefs.FS, site *SiteMetadata) (map[string]*Article, error) {
 	articles := make(map[string]*Article)
 	var seriesPaths []string
+	var noMetaPaths []string
 
 	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if d.IsDir() || !strings.HasSuffix(d.Name(), DjotExt) {
@@ -451,7 +796,7 @@ func findArticles(fsys writablefs.FS, site *SiteMetadata) (map[string]*Article,
 
 		metaText, bodyText := SeparateMetadata(file)
 		if len(metaText) == 0 {
-			fmt.Printf("FIXME: Metadata not found in %s\n", path)
+			noMetaPaths = append(noMetaPaths, path)
 			return nil
 		}
 
@@ -486,9 +831,19 @@ func findArticles(fsys writablefs.FS, site *SiteMetadata) (map[string]*Article,
 			Path:            path,
 			OutputPath:      strings.TrimSuffix(path, DjotExt) + ".html",
 			DjotBody:        bodyText,
+			BodyLine:        bytes.Count(metaText, []byte("\n")) + 2,
 			ArticleMetadata: meta,
 		}
-		article.ComputeDerivedFields(site.Address, site.Root)
+
+		body, lineMap, includes, userErr := expandIncludes(fsys, &article)
+		if userErr != nil {
+			return userErr
+		}
+		article.DjotBody = body
+		article.bodyLineMap = lineMap
+		article.Includes = includes
+
+		article.ComputeDerivedFields(site)
 
 		if article.PageType == PTSeriesIndex {
 			seriesPaths = append(seriesPaths, article.Path)
@@ -502,6 +857,20 @@ func findArticles(fsys writablefs.FS, site *SiteMetadata) (map[string]*Article,
 		return nil, err
 	}
 
+	// Djot files without metadata are fine as long as they're only meant to
+	// be included into other articles.
+	included := make(map[string]bool)
+	for _, a := range articles {
+		for _, p := range a.Includes {
+			included[p] = true
+		}
+	}
+	for _, p := range noMetaPaths {
+		if !included[p] {
+			fmt.Printf("FIXME: Metadata not found in %s\n", p)
+		}
+	}
+
 	// TODO: there must be a more... elegant way?
 	for _, sPath := range seriesPaths {
 		for aPath, a := range articles {
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.imnhan.com/s4g/djot"
+)
+
+func TestHeadingLinkOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func(*SiteMetadata)
+		want      *djot.HeadingLinks
+		wantField string
+	}{
+		{
+			name: "defaults",
+			edit: func(s *SiteMetadata) {},
+			want: &djot.HeadingLinks{
+				Symbol: "#", Class: "heading-link", Levels: []int{1, 2, 3, 4, 5, 6},
+			},
+		},
+		{
+			name: "disabled",
+			edit: func(s *SiteMetadata) { s.HeadingLinks = false },
+			want: nil,
+		},
+		{
+			name: "custom",
+			edit: func(s *SiteMetadata) {
+				s.HeadingLinkSymbol = "<i>§</i>"
+				s.HeadingLinkPosition = "before"
+				s.HeadingLinkClass = ""
+				s.HeadingLinkLevels = []int{2, 3}
+			},
+			want: &djot.HeadingLinks{
+				Symbol: "<i>§</i>", Before: true, Class: "", Levels: []int{2, 3},
+			},
+		},
+		{
+			name:      "invalid position",
+			edit:      func(s *SiteMetadata) { s.HeadingLinkPosition = "left" },
+			wantField: "HeadingLinkPosition",
+		},
+		{
+			name:      "invalid level",
+			edit:      func(s *SiteMetadata) { s.HeadingLinkLevels = []int{1, 7} },
+			wantField: "HeadingLinkLevels",
+		},
+	}
+	for _, tt := range tests {
+		site := NewSiteMetadata()
+		tt.edit(&site)
+		got, uerr := headingLinkOptions(&site)
+		if tt.wantField != "" {
+			if uerr == nil || uerr.Field != tt.wantField || uerr.File != SettingsPath {
+				t.Errorf("%s: got error %+v, want one for %s", tt.name, uerr, tt.wantField)
+			}
+			continue
+		}
+		if uerr != nil {
+			t.Errorf("%s: unexpected error %+v", tt.name, uerr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
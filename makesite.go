@@ -28,6 +28,8 @@ func makeSite(path string, meta SiteMetadata) error {
 
 	// Copy default theme into new site
 	copyTheme(defaultTheme, filepath.Dir(path+"/"+ThemePath))
+	// ...and keep a pristine copy for `s4g theme -upgrade`
+	copyTheme(builtinTheme, path+"/"+ThemeBasePath)
 
 	// Write default index page
 	indexData := []byte(`Title: Home
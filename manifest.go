@@ -2,45 +2,195 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"sort"
 	"strings"
 
 	"go.imnhan.com/s4g/writablefs"
 )
 
-// Write list of files generated by s4g
-func WriteManifest(fsys writablefs.FS, files map[string]bool) {
-	lines := make([]string, 0, len(files))
+// Fingerprint of manifest entries that are redirect sources without a
+// generated file, see generateRedirects.
+const redirectSourceMark = "redirect"
+
+// Write list of files generated by s4g.
+//
+// Each line is a file path, optionally followed by a tab and the file's
+// fingerprint, which is used to detect moved articles on the next run.
+// Redirect sources are marked with redirectSourceMark instead.
+func WriteManifest(
+	fsys writablefs.FS,
+	files map[string]bool,
+	fingerprints map[string]string,
+	redirectSources []string,
+) {
+	lines := make([]string, 0, len(files)+len(redirectSources))
 	for path := range files {
+		if fp := fingerprints[path]; fp != "" {
+			path += "\t" + fp
+		}
 		lines = append(lines, path)
 	}
+	for _, path := range redirectSources {
+		if !files[path] {
+			lines = append(lines, path+"\t"+redirectSourceMark)
+		}
+	}
 	sort.Strings(lines)
 	fsys.WriteFile(ManifestPath, []byte(strings.Join(lines, "\n")))
 }
 
+// Identifies an article by both its title and its content, so that it can
+// still be recognized after a move even when one of them was edited.
+func articleFingerprint(a *Article) string {
+	return shortHash([]byte(a.Title)) + " " + shortHash(a.DjotBody)
+}
+
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
 // Read list of old generated files from the manifest file,
 // then delete those that are no longer relevant.
-func DeleteOldGeneratedFiles(fsys writablefs.FS, currentFiles map[string]bool) {
-	oldFiles := readManifest(fsys)
+//
+// Deleted articles whose fingerprint matches a newly generated one are
+// considered moved. Depending on autoRedirect, a redirect rule is either
+// appended to redirects.txt or printed as a suggestion, so that old URLs
+// don't silently 404. Paths in redirected, e.g. an article's Aliases or a
+// wildcard rule's matches, are already taken care of, so they're skipped.
+// Returns whether redirects.txt was changed.
+func DeleteOldGeneratedFiles(
+	fsys writablefs.FS,
+	currentFiles map[string]bool,
+	fingerprints map[string]string,
+	redirected map[string]bool,
+	autoRedirect bool,
+) (redirectsChanged bool) {
+	oldFingerprints := readFingerprints(fsys)
 	numRemovals := 0
+	var moves []Redirect
 
-	for path := range oldFiles {
+	for path, fp := range oldFingerprints {
 		_, ok := currentFiles[path]
-		if !ok {
+		if !ok && fp != redirectSourceMark {
 			fsys.RemoveAll(path)
 			numRemovals += 1
 			fmt.Println("Removed", path)
+
+			if redirected[path] {
+				continue
+			}
+			dest := findMoveDest(path, oldFingerprints, fingerprints)
+			if dest != "" {
+				moves = append(moves, Redirect{Src: path, Dest: dest})
+			}
 		}
 	}
 
 	if numRemovals > 0 {
 		fmt.Printf("Removed %d outdated files\n", numRemovals)
 	}
+
+	if len(moves) == 0 {
+		return false
+	}
+
+	sort.Slice(moves, func(i, j int) bool { return moves[i].Src < moves[j].Src })
+	var rules strings.Builder
+	for _, m := range moves {
+		fmt.Fprintf(&rules, "%s -> %s\n", m.Src, m.Dest)
+	}
+
+	if !autoRedirect {
+		fmt.Printf(
+			"Looks like some articles were moved. To keep their old URLs working, add these to %s:\n\n%s\n",
+			RedirectsPath, rules.String(),
+		)
+		return false
+	}
+
+	content, err := fs.ReadFile(fsys, RedirectsPath)
+	if err != nil {
+		panic(err)
+	}
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	content = append(content, rules.String()...)
+	err = fsys.WriteFile(RedirectsPath, content)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Added redirects for moved articles to %s:\n%s", RedirectsPath, rules.String())
+	return true
+}
+
+// Finds the newly generated article that oldPath was moved to, if any.
+// Content is a stronger signal than title so it's checked first, and only
+// unambiguous matches count.
+//
+// Open Graph cards have fingerprints too, but only to know when to redraw
+// them, so they're not considered here.
+func findMoveDest(
+	oldPath string,
+	oldFingerprints map[string]string,
+	fingerprints map[string]string,
+) string {
+	if isOGCard(oldPath) {
+		return ""
+	}
+	oldTitle, oldContent, ok := strings.Cut(oldFingerprints[oldPath], " ")
+	if !ok {
+		return ""
+	}
+
+	var sameContent, sameTitle []string
+	for path, fp := range fingerprints {
+		if _, ok := oldFingerprints[path]; ok || isOGCard(path) {
+			continue
+		}
+		title, content, _ := strings.Cut(fp, " ")
+		if content == oldContent {
+			sameContent = append(sameContent, path)
+		}
+		if title == oldTitle {
+			sameTitle = append(sameTitle, path)
+		}
+	}
+
+	var dest string
+	if len(sameContent) == 1 {
+		dest = sameContent[0]
+	} else if len(sameContent) == 0 && len(sameTitle) == 1 {
+		dest = sameTitle[0]
+	} else {
+		return ""
+	}
+	return strings.TrimSuffix(dest, "index.html")
+}
+
+// Returns files generated last time, and redirect sources that had no file.
+func readManifest(fsys writablefs.FS) (files, redirectSources map[string]bool) {
+	files = make(map[string]bool)
+	redirectSources = make(map[string]bool)
+	for path, fp := range readFingerprints(fsys) {
+		if fp == redirectSourceMark {
+			redirectSources[path] = true
+		} else {
+			files[path] = true
+		}
+	}
+	return files, redirectSources
 }
 
-func readManifest(fsys writablefs.FS) map[string]bool {
-	result := make(map[string]bool)
+// Maps each entry in the manifest to its fingerprint, which is empty for
+// files that aren't articles.
+func readFingerprints(fsys writablefs.FS) map[string]string {
+	result := make(map[string]string)
 
 	f, err := fsys.Open(ManifestPath)
 	if err != nil {
@@ -50,7 +200,8 @@ func readManifest(fsys writablefs.FS) map[string]bool {
 
 	s := bufio.NewScanner(f)
 	for s.Scan() {
-		result[s.Text()] = true
+		path, fp, _ := strings.Cut(s.Text(), "\t")
+		result[path] = fp
 	}
 	return result
 }
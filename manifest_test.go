@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{SettingsPath: ""})
+	WriteManifest(
+		fsys,
+		map[string]bool{"a.html": true, "posts/b.html": true, "feed.xml": true},
+		map[string]string{"posts/b.html": "t1 c1"},
+		[]string{"old.html", "a.html"},
+	)
+
+	files, sources := readManifest(fsys)
+	wantFiles := []string{"a.html", "posts/b.html", "feed.xml"}
+	if len(files) != len(wantFiles) {
+		t.Errorf("files = %v, want %v", files, wantFiles)
+	}
+	for _, f := range wantFiles {
+		if !files[f] {
+			t.Errorf("files = %v, missing %s", files, f)
+		}
+	}
+	// A source that also has a generated file is just a file
+	if len(sources) != 1 || !sources["old.html"] {
+		t.Errorf("redirect sources = %v, want only old.html", sources)
+	}
+
+	fps := readFingerprints(fsys)
+	if fps["posts/b.html"] != "t1 c1" || fps["a.html"] != "" {
+		t.Errorf("fingerprints = %v", fps)
+	}
+}
+
+func TestDeleteOldGeneratedFiles(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		RedirectsPath: "",
+		ManifestPath: "kept.html\n" +
+			"stale.html\n" +
+			"stale.og.png\t0123456789abcdef\n" +
+			"moved-src.html\tredirect\n",
+		"kept.html":    "",
+		"stale.html":   "",
+		"stale.og.png": "",
+		// Only a redirect source last time, now a user's static file
+		"moved-src.html": "",
+		"static.html":    "",
+	})
+
+	changed := DeleteOldGeneratedFiles(
+		fsys, map[string]bool{"kept.html": true}, map[string]string{}, nil, true,
+	)
+	if changed {
+		t.Error("redirects.txt shouldn't change without moved articles")
+	}
+	for p, wantExists := range map[string]bool{
+		"kept.html":      true,
+		"stale.html":     false,
+		"stale.og.png":   false,
+		"moved-src.html": true,
+		"static.html":    true,
+	} {
+		if got := fileExists(fsys, p); got != wantExists {
+			t.Errorf("%s exists = %v, want %v", p, got, wantExists)
+		}
+	}
+}
+
+func TestDeleteOldGeneratedFilesAddsMoveRedirects(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		RedirectsPath:   "a.html -> b.html",
+		ManifestPath:    "old/post.html\tt1 c1\n",
+		"old/post.html": "",
+	})
+
+	changed := DeleteOldGeneratedFiles(
+		fsys,
+		map[string]bool{"new/post/index.html": true},
+		map[string]string{"new/post/index.html": "t1 c1"},
+		nil,
+		true,
+	)
+	if !changed {
+		t.Fatal("expected redirects.txt to change")
+	}
+	content, err := fs.ReadFile(fsys, RedirectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "a.html -> b.html\nold/post.html -> new/post/\n"
+	if string(content) != want {
+		t.Errorf("redirects.txt = %q, want %q", content, want)
+	}
+
+	// Without autoRedirect, the rules are only suggested
+	fsys = newTestFS(t, map[string]string{
+		RedirectsPath: "",
+		ManifestPath:  "old.html\tt1 c1\n",
+	})
+	changed = DeleteOldGeneratedFiles(
+		fsys,
+		map[string]bool{"new.html": true},
+		map[string]string{"new.html": "t1 c1"},
+		nil,
+		false,
+	)
+	content, _ = fs.ReadFile(fsys, RedirectsPath)
+	if changed || len(content) != 0 {
+		t.Errorf("changed = %v, redirects.txt = %q", changed, content)
+	}
+
+	// Already redirected, e.g. by the new article's Aliases or a wildcard
+	// rule, so a second rule would be a duplicate source.
+	fsys = newTestFS(t, map[string]string{
+		RedirectsPath: "",
+		ManifestPath:  "old.html\tt1 c1\nold/a.html\tt2 c2\n",
+		"old.html":    "",
+	})
+	changed = DeleteOldGeneratedFiles(
+		fsys,
+		map[string]bool{"new.html": true, "new/a.html": true},
+		map[string]string{"new.html": "t1 c1", "new/a.html": "t2 c2"},
+		map[string]bool{"old.html": true, "old/a.html": true},
+		true,
+	)
+	content, _ = fs.ReadFile(fsys, RedirectsPath)
+	if changed || len(content) != 0 {
+		t.Errorf("changed = %v, redirects.txt = %q", changed, content)
+	}
+	if fileExists(fsys, "old.html") {
+		t.Error("old.html should still be deleted")
+	}
+}
+
+func TestFindMoveDest(t *testing.T) {
+	tests := []struct {
+		name string
+		old  map[string]string
+		new  map[string]string
+		want string
+	}{
+		{
+			name: "same content, edited title",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t2 c1"},
+			want: "b.html",
+		},
+		{
+			name: "same title, edited content",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t1 c2"},
+			want: "b.html",
+		},
+		{
+			name: "content wins over title",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t1 c2", "c.html": "t2 c1"},
+			want: "c.html",
+		},
+		{
+			name: "ambiguous content",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t1 c1", "c.html": "t2 c1"},
+			want: "",
+		},
+		{
+			name: "ambiguous title",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t1 c2", "c.html": "t1 c3"},
+			want: "",
+		},
+		{
+			name: "existing files aren't move destinations",
+			old:  map[string]string{"a.html": "t1 c1", "b.html": "t1 c1"},
+			new:  map[string]string{"b.html": "t1 c1"},
+			want: "",
+		},
+		{
+			name: "index page becomes folder",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b/index.html": "t1 c1"},
+			want: "b/",
+		},
+		{
+			name: "not an article",
+			old:  map[string]string{"a.html": ""},
+			new:  map[string]string{"b.html": "t1 c1"},
+			want: "",
+		},
+		{
+			name: "open graph cards are ignored",
+			old:  map[string]string{"a.og.png": "t1 c1"},
+			new:  map[string]string{"b.og.png": "t1 c1"},
+			want: "",
+		},
+		{
+			name: "open graph cards aren't move destinations",
+			old:  map[string]string{"a.html": "t1 c1"},
+			new:  map[string]string{"b.og.png": "t1 c1"},
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var oldPath string
+			for p := range tt.old {
+				if p == "a.html" || p == "a.og.png" {
+					oldPath = p
+				}
+			}
+			if got := findMoveDest(oldPath, tt.old, tt.new); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -29,6 +29,24 @@ type SiteMetadata struct {
 	AuthorURI     string
 	AuthorEmail   string
 	AuthorTwitter string
+
+	RedirectFormats   []string
+	AutoRedirectMoves bool
+	GenerateLinkGraph bool
+	HighlightStyle    string
+	ImageWidths       []int
+	GenerateOGCards   bool
+	OGCardBackground  string
+	OGCardFont        string
+	DjotFilters       []string
+	SummaryWords      int
+	WordsPerMinute    int
+
+	HeadingLinks        bool
+	HeadingLinkSymbol   template.HTML
+	HeadingLinkPosition string
+	HeadingLinkClass    string
+	HeadingLinkLevels   []int
 }
 
 type PageType int
@@ -79,6 +97,10 @@ type ArticleMetadata struct {
 	Templates   []string
 	ShowInFeed  bool
 	Thumb       string
+	Author      string
+	AuthorEmail string
+	Aliases     []string
+	ShowTOC     bool
 }
 
 func NewSiteMetadata() SiteMetadata {
@@ -96,6 +118,24 @@ func NewSiteMetadata() SiteMetadata {
 		AuthorURI:     "https://example.com/scoop",
 		AuthorEmail:   "scoopidoo@example.com",
 		AuthorTwitter: "",
+
+		RedirectFormats:   []string{RFHtml},
+		AutoRedirectMoves: false,
+		GenerateLinkGraph: false,
+		HighlightStyle:    "github",
+		ImageWidths:       []int{480, 960, 1440},
+		GenerateOGCards:   false,
+		OGCardBackground:  "",
+		OGCardFont:        "",
+		DjotFilters:       nil,
+		SummaryWords:      0,
+		WordsPerMinute:    200,
+
+		HeadingLinks:        true,
+		HeadingLinkSymbol:   "#",
+		HeadingLinkPosition: "after",
+		HeadingLinkClass:    "heading-link",
+		HeadingLinkLevels:   []int{1, 2, 3, 4, 5, 6},
 	}
 }
 
@@ -201,6 +241,24 @@ func UnmarshalMetadata(data []byte, dest any) *errs.UserErr {
 				}
 				s.Field(i).Set(reflect.ValueOf(trimmed))
 
+			case "[]int":
+				var ints []int
+				for _, part := range strings.Split(val, ",") {
+					part = strings.TrimSpace(part)
+					if part == "" {
+						continue
+					}
+					intVal, err := strconv.Atoi(part)
+					if err != nil {
+						return &errs.UserErr{
+							Field: fieldName,
+							Msg:   fmt.Sprintf(`invalid int: "%s"`, err),
+						}
+					}
+					ints = append(ints, intVal)
+				}
+				s.Field(i).Set(reflect.ValueOf(ints))
+
 			case "main.PageType":
 				pt, err := ParsePageType(val)
 				if err != nil {
@@ -240,6 +298,12 @@ func MarshalMetadata(v any) []byte {
 		switch f.Type().String() {
 		case "[]string":
 			repr = strings.Join(val.([]string), ", ")
+		case "[]int":
+			parts := make([]string, len(val.([]int)))
+			for i, n := range val.([]int) {
+				parts[i] = strconv.Itoa(n)
+			}
+			repr = strings.Join(parts, ", ")
 		default:
 			repr = fmt.Sprintf("%v", val)
 		}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"go.imnhan.com/s4g/errs"
+	"go.imnhan.com/s4g/writablefs"
+)
+
+// Open Graph cards are generated next to their article's html file,
+// e.g. "posts/hello.html" => "posts/hello.og.png"
+const OGCardSuffix = ".og.png"
+
+// Recommended size for Open Graph images
+const ogCardWidth = 1200
+const ogCardHeight = 630
+const ogCardPadding = 80
+const ogCardMaxTitleLines = 4
+
+// Part of each card's fingerprint. Bump when changing how cards are drawn,
+// so that existing cards are redrawn.
+const ogCardLayoutVersion = "2"
+
+var ogCardBackgroundColor = color.RGBA{0x1d, 0x1f, 0x21, 0xff}
+var ogCardOverlayColor = color.RGBA{0, 0, 0, 0x99}
+var ogCardTitleColor = color.White
+var ogCardSiteColor = color.RGBA{0xc5, 0xc8, 0xc6, 0xff}
+
+func ogCardPath(a *Article) string {
+	return strings.TrimSuffix(a.OutputPath, ".html") + OGCardSuffix
+}
+
+func isOGCard(p string) bool {
+	return strings.HasSuffix(p, OGCardSuffix)
+}
+
+// Renders an Open Graph card for every article that doesn't have its own
+// Thumb. Cards are only redrawn when their inputs changed, which is checked
+// against the fingerprints recorded in the previous manifest.
+//
+// Titles with characters that the font can't draw are reported to warn,
+// since they'd show up as boxes.
+func generateOGCards(
+	fsys writablefs.FS,
+	site *SiteMetadata,
+	articles map[string]*Article,
+	generatedFiles map[string]bool,
+	fingerprints map[string]string,
+	warn func(*errs.UserErr),
+) error {
+	var background []byte
+	if site.OGCardBackground != "" {
+		var err error
+		background, err = fs.ReadFile(fsys, site.OGCardBackground)
+		if err != nil {
+			return &errs.UserErr{
+				File:  SettingsPath,
+				Field: "OGCardBackground",
+				Msg:   err.Error(),
+			}
+		}
+	}
+
+	// A custom font is used for both the title and the site's name
+	titleFont, siteFont := gobold.TTF, goregular.TTF
+	if site.OGCardFont != "" {
+		custom, err := fs.ReadFile(fsys, site.OGCardFont)
+		if err != nil {
+			return &errs.UserErr{
+				File:  SettingsPath,
+				Field: "OGCardFont",
+				Msg:   err.Error(),
+			}
+		}
+		titleFont, siteFont = custom, custom
+	}
+	titleFace, err := loadFace(titleFont, 64)
+	if err != nil {
+		return &errs.UserErr{
+			File:  SettingsPath,
+			Field: "OGCardFont",
+			Msg:   fmt.Sprintf("invalid font: %s", err),
+		}
+	}
+	siteFace, err := loadFace(siteFont, 32)
+	if err != nil {
+		panic(err)
+	}
+
+	fontHash := shortHash(titleFont)
+	oldFingerprints := readFingerprints(fsys)
+	var drawer *ogCardDrawer
+
+	for _, a := range sortedArticles(articles) {
+		if a.Thumb != "" {
+			continue
+		}
+		if missing := missingGlyphs(titleFace, a.Title); len(missing) > 0 {
+			warn(&errs.UserErr{
+				File:  a.Path,
+				Field: "Title",
+				Msg: fmt.Sprintf(
+					`Open Graph card font has no glyphs for %s, set OGCardFont to a font that does`,
+					strings.Join(missing, ", "),
+				),
+			})
+		}
+
+		path := ogCardPath(a)
+		fp := shortHash([]byte(
+			ogCardLayoutVersion + "\n" + a.Title + "\n" + site.Name + "\n" +
+				string(background) + "\n" + fontHash,
+		))
+		generatedFiles[path] = true
+		fingerprints[path] = fp
+
+		if oldFingerprints[path] == fp && fileExists(fsys, path) {
+			continue
+		}
+
+		if drawer == nil {
+			var uerr *errs.UserErr
+			drawer, uerr = newOGCardDrawer(background, titleFace, siteFace)
+			if uerr != nil {
+				return uerr
+			}
+		}
+		err := fsys.WriteFile(path, drawer.Draw(a.Title, site.Name))
+		if err != nil {
+			return fmt.Errorf("Failed to write to %s: %w", path, err)
+		}
+		fmt.Println("Generated", path)
+	}
+	return nil
+}
+
+type ogCardDrawer struct {
+	background image.Image
+	titleFace  font.Face
+	siteFace   font.Face
+}
+
+func newOGCardDrawer(
+	background []byte, titleFace, siteFace font.Face,
+) (*ogCardDrawer, *errs.UserErr) {
+	bg := image.NewRGBA(image.Rect(0, 0, ogCardWidth, ogCardHeight))
+	if background == nil {
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(ogCardBackgroundColor), image.Point{}, draw.Src)
+	} else {
+		img, _, err := image.Decode(bytes.NewReader(background))
+		if err != nil {
+			return nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "OGCardBackground",
+				Msg:   fmt.Sprintf("invalid image: %s", err),
+			}
+		}
+		draw.CatmullRom.Scale(bg, bg.Bounds(), img, img.Bounds(), draw.Src, nil)
+		// Darken so that text stays readable on busy backgrounds
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(ogCardOverlayColor), image.Point{}, draw.Over)
+	}
+
+	return &ogCardDrawer{
+		background: bg,
+		titleFace:  titleFace,
+		siteFace:   siteFace,
+	}, nil
+}
+
+// Accepts TrueType and OpenType fonts.
+func loadFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// Returns the distinct characters in text that face can't draw, quoted.
+func missingGlyphs(face font.Face, text string) []string {
+	var missing []string
+	seen := make(map[rune]bool)
+	for _, r := range text {
+		if seen[r] || unicode.IsSpace(r) {
+			continue
+		}
+		seen[r] = true
+		if _, ok := face.GlyphAdvance(r); !ok {
+			missing = append(missing, strconv.Quote(string(r)))
+		}
+	}
+	return missing
+}
+
+func (d *ogCardDrawer) Draw(title string, siteName string) []byte {
+	img := image.NewRGBA(d.background.Bounds())
+	draw.Draw(img, img.Bounds(), d.background, image.Point{}, draw.Src)
+
+	maxWidth := ogCardWidth - 2*ogCardPadding
+	lineHeight := d.titleFace.Metrics().Height.Ceil() * 5 / 4
+	y := ogCardPadding + d.titleFace.Metrics().Ascent.Ceil()
+	for _, line := range wrapText(d.titleFace, title, maxWidth, ogCardMaxTitleLines) {
+		drawText(img, d.titleFace, ogCardTitleColor, line, ogCardPadding, y)
+		y += lineHeight
+	}
+
+	drawText(
+		img, d.siteFace, ogCardSiteColor, siteName,
+		ogCardPadding, ogCardHeight-ogCardPadding,
+	)
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func drawText(img draw.Image, face font.Face, c color.Color, text string, x, y int) {
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// Splits text into lines that fit in maxWidth. Words that are too wide on
+// their own, e.g. long URLs or text without spaces such as Chinese (given
+// an OGCardFont that has its glyphs), are broken between characters. If there are more than maxLines lines, the last
+// one is truncated with an ellipsis.
+func wrapText(face font.Face, text string, maxWidth int, maxLines int) []string {
+	fits := func(s string) bool {
+		return font.MeasureString(face, s).Ceil() <= maxWidth
+	}
+
+	var lines []string
+	var current string
+	for _, word := range strings.Fields(text) {
+		if !fits(word) {
+			if current != "" {
+				lines = append(lines, current)
+			}
+			chunks := breakWord(word, fits)
+			lines = append(lines, chunks[:len(chunks)-1]...)
+			current = chunks[len(chunks)-1]
+			continue
+		}
+
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if fits(candidate) {
+			current = candidate
+			continue
+		}
+		lines = append(lines, current)
+		current = word
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+
+	if len(lines) <= maxLines {
+		return lines
+	}
+	lines = lines[:maxLines]
+	last := lines[maxLines-1]
+	for last != "" && !fits(last+"…") {
+		_, size := utf8.DecodeLastRuneInString(last)
+		last = strings.TrimRight(last[:len(last)-size], " ")
+	}
+	lines[maxLines-1] = last + "…"
+	return lines
+}
+
+// Splits word at rune boundaries into chunks that each fit, except for a
+// single rune that doesn't fit even on its own.
+func breakWord(word string, fits func(string) bool) []string {
+	var chunks []string
+	var current string
+	for _, r := range word {
+		if current != "" && !fits(current+string(r)) {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		current += string(r)
+	}
+	return append(chunks, current)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/gofont/gobold"
+)
+
+func TestWrapText(t *testing.T) {
+	// Every glyph is 7px wide, so a line fits 10 runes
+	face := basicfont.Face7x13
+	tests := []struct {
+		name     string
+		text     string
+		maxLines int
+		want     []string
+	}{
+		{
+			name:     "fits",
+			text:     "short",
+			maxLines: 3,
+			want:     []string{"short"},
+		},
+		{
+			name:     "wraps at spaces",
+			text:     "hello  world foo",
+			maxLines: 3,
+			want:     []string{"hello", "world foo"},
+		},
+		{
+			name:     "breaks long words",
+			text:     "hi abcdefghijklmnopqrstuvwxyz end",
+			maxLines: 5,
+			want:     []string{"hi", "abcdefghij", "klmnopqrst", "uvwxyz end"},
+		},
+		{
+			name:     "breaks at rune boundaries",
+			text:     "ééééééééééééé",
+			maxLines: 3,
+			want:     []string{"éééééééééé", "ééé"},
+		},
+		{
+			name:     "truncates extra lines",
+			text:     "one two three four five six",
+			maxLines: 2,
+			want:     []string{"one two", "three fou…"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := wrapText(face, tt.text, 70, tt.maxLines)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for _, line := range got {
+				if !utf8.ValidString(line) {
+					t.Errorf("invalid utf-8 in %q", line)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakWord(t *testing.T) {
+	fits := func(s string) bool { return utf8.RuneCountInString(s) <= 2 }
+	tests := map[string][]string{
+		"a":     {"a"},
+		"ab":    {"ab"},
+		"abcde": {"ab", "cd", "e"},
+		"日本語":   {"日本", "語"},
+	}
+	for word, want := range tests {
+		if got := breakWord(word, fits); !reflect.DeepEqual(got, want) {
+			t.Errorf("breakWord(%q) = %q, want %q", word, got, want)
+		}
+	}
+
+	// A rune that doesn't fit on its own still gets its own chunk
+	never := func(string) bool { return false }
+	if got := breakWord("ab", never); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMissingGlyphs(t *testing.T) {
+	face, err := loadFace(gobold.TTF, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, world!", nil},
+		{"Ελληνικά и кириллица", nil},
+		{"Tiếng Việt", []string{`"ế"`, `"ệ"`}},
+		{"中文 中", []string{`"中"`, `"文"`}},
+	}
+	for _, tt := range tests {
+		if got := missingGlyphs(face, tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("missingGlyphs(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	if _, err := loadFace([]byte("not a font"), 64); err == nil {
+		t.Error("expected error for invalid font")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Meta refresh html pages, one per redirect source.
+const RFHtml = "html"
+
+var redirectStatuses = []string{"301", "302", "303", "307", "308"}
+
+// A host-native redirect config format, rendered into a single file.
+type redirectFormat struct {
+	Path   string
+	Render func(root string, redirects []Redirect) []byte
+}
+
+var redirectFormats = map[string]redirectFormat{
+	"netlify": {Path: "_redirects", Render: renderNetlifyRedirects},
+	"apache":  {Path: ".htaccess", Render: renderApacheRedirects},
+	"nginx":   {Path: S4gDir + "/redirects.nginx", Render: renderNginxRedirects},
+	"caddy":   {Path: S4gDir + "/redirects.caddy", Render: renderCaddyRedirects},
+}
+
+var redirectConfigHeader = "# Generated by s4g from " + RedirectsPath + "\n"
+
+func redirectFormatNames() []string {
+	names := []string{RFHtml}
+	for name := range redirectFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Whether path is a host-native redirect config generated by s4g.
+func isRedirectConfig(path string) bool {
+	for _, f := range redirectFormats {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// https://docs.netlify.com/routing/redirects/
+func renderNetlifyRedirects(root string, redirects []Redirect) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(redirectConfigHeader)
+	for _, r := range redirects {
+		for _, src := range r.webSources(root) {
+			fmt.Fprintf(
+				&buf, "%s %s %d\n", escapePath(src), r.webDest(root), r.Status,
+			)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Uses RedirectMatch instead of Redirect because the latter also matches
+// everything under the source path.
+func renderApacheRedirects(root string, redirects []Redirect) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(redirectConfigHeader)
+	for _, r := range redirects {
+		for _, src := range r.webSources(root) {
+			fmt.Fprintf(
+				&buf, "RedirectMatch %d \"^%s$\" \"%s\"\n",
+				r.Status, regexp.QuoteMeta(src), r.webDest(root),
+			)
+		}
+	}
+	return buf.Bytes()
+}
+
+// Nginx's return directive only accepts a literal status code, so there's
+// one map per status. The header explains how to use them.
+func renderNginxRedirects(root string, redirects []Redirect) []byte {
+	byStatus := make(map[int][]Redirect)
+	var statuses []int
+	for _, r := range redirects {
+		if _, ok := byStatus[r.Status]; !ok {
+			statuses = append(statuses, r.Status)
+		}
+		byStatus[r.Status] = append(byStatus[r.Status], r)
+	}
+	sort.Ints(statuses)
+
+	var buf bytes.Buffer
+	buf.WriteString(redirectConfigHeader)
+	buf.WriteString("#\n# Include this file in your http block, then add to your server block:\n")
+	for _, status := range statuses {
+		fmt.Fprintf(
+			&buf, "#   if ($s4g_redirect_%d) { return %d $s4g_redirect_%d; }\n",
+			status, status, status,
+		)
+	}
+
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "\nmap $uri $s4g_redirect_%d {\n", status)
+		for _, r := range byStatus[status] {
+			for _, src := range r.webSources(root) {
+				fmt.Fprintf(
+					&buf, "  %s %s;\n",
+					strconv.Quote(src), strconv.Quote(r.webDest(root)),
+				)
+			}
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes()
+}
+
+// Meant to be imported in a site block:
+//
+//	import /path/to/redirects.caddy
+func renderCaddyRedirects(root string, redirects []Redirect) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(redirectConfigHeader)
+	for _, r := range redirects {
+		for _, src := range r.webSources(root) {
+			fmt.Fprintf(
+				&buf, "redir %s %s %d\n",
+				strconv.Quote(src), strconv.Quote(r.webDest(root)), r.Status,
+			)
+		}
+	}
+	return buf.Bytes()
+}
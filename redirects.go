@@ -6,25 +6,245 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
+	"net/http"
+	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"go.imnhan.com/s4g/errs"
 	"go.imnhan.com/s4g/writablefs"
 )
 
-// Returns list of generated files
+const redirectWildcard = "*"
+
+const defaultRedirectStatus = http.StatusMovedPermanently
+
+// A single "src -> dest [status] [delay=seconds]" line in redirects.txt.
+// Before expansion, Src and Dest may contain one wildcard each.
+type Redirect struct {
+	Src  string
+	Dest string
+
+	// Where the redirect was defined, for error reporting. Redirects from
+	// redirects.txt have a Line, while those generated from an article's
+	// metadata have a Field instead.
+	File  string
+	Line  int
+	Field string
+
+	// HTTP status used by host-native configs and the local server.
+	Status int
+
+	// Seconds to wait before redirecting. Only meta refresh html pages can
+	// do this, host-native configs always redirect immediately.
+	Delay int
+}
+
+func (r *Redirect) userErr(msg string) *errs.UserErr {
+	return &errs.UserErr{File: r.File, Line: r.Line, Field: r.Field, Msg: msg}
+}
+
+// Human-readable location of the redirect's definition.
+func (r *Redirect) origin() string {
+	if r.Field != "" {
+		return fmt.Sprintf("%s (%s)", r.File, r.Field)
+	}
+	return fmt.Sprintf("%s line %d", r.File, r.Line)
+}
+
+// Whether Dest points to another website instead of a file in this one.
+func (r *Redirect) IsExternal() bool {
+	return isExternalURL(r.Dest)
+}
+
+// Only protocol-relative and http(s) URLs count, so that a local path with a
+// colon in it, e.g. "a:b.html", isn't mistaken for a URL with scheme "a".
+func isExternalURL(s string) bool {
+	if strings.HasPrefix(s, "//") {
+		return true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	return scheme == "http" || scheme == "https"
+}
+
+// Full URL or web path that the redirect should point to.
+func (r *Redirect) webDest(root string) string {
+	if r.IsExternal() {
+		return r.Dest
+	}
+	target := redirectTarget(r.Dest)
+	return root + escapePath(target) + r.Dest[len(target):]
+}
+
+func (r *Redirect) IsWildcard() bool {
+	return strings.Contains(r.Src, redirectWildcard)
+}
+
+// Returns the part of path matched by the rule's wildcard.
+func (r *Redirect) match(path string) (captured string, ok bool) {
+	prefix, suffix, _ := strings.Cut(r.Src, redirectWildcard)
+	if len(path) < len(prefix)+len(suffix) ||
+		!strings.HasPrefix(path, prefix) ||
+		!strings.HasSuffix(path, suffix) {
+		return "", false
+	}
+	return path[len(prefix) : len(path)-len(suffix)], true
+}
+
+// Returns the expanded redirects, list of generated files, and redirect
+// sources that have no generated file because the html format is disabled.
+//
+// Those sources are still recorded in the manifest (see WriteManifest) so
+// that wildcard rules keep expanding to the same sources on subsequent runs,
+// but they aren't generated files: a meta refresh page left over from when
+// the html format was enabled must be deleted, otherwise hosts would serve
+// it instead of applying their own redirect rules.
 func generateRedirects(
-	fsys writablefs.FS, path string, root string,
-) (generated []string, uerr *errs.UserErr) {
+	fsys writablefs.FS,
+	path string,
+	root string,
+	formats []string,
+	aliases []Redirect,
+	oldFiles map[string]bool,
+	oldSources map[string]bool,
+	currentFiles map[string]bool,
+	warn func(*errs.UserErr),
+) (redirects []Redirect, generated []string, sources []string, uerr *errs.UserErr) {
+	for _, f := range formats {
+		if f == RFHtml {
+			continue
+		}
+		format, ok := redirectFormats[f]
+		if !ok {
+			return nil, nil, nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "RedirectFormats",
+				Msg: fmt.Sprintf(
+					`"%s" is not a valid redirect format, expected one of: %s`,
+					f, strings.Join(redirectFormatNames(), ", "),
+				),
+			}
+		}
+		// Same as redirect sources: don't overwrite a hand-written config.
+		if !oldFiles[format.Path] && fileExists(fsys, format.Path) {
+			return nil, nil, nil, &errs.UserErr{
+				File:  SettingsPath,
+				Field: "RedirectFormats",
+				Msg: fmt.Sprintf(
+					`%s already exists but wasn't generated by s4g, so the "%s" format would overwrite it`,
+					format.Path, f,
+				),
+			}
+		}
+	}
+
+	rules, uerr := parseRedirects(fsys, path)
+	if uerr != nil {
+		return nil, nil, nil, uerr
+	}
+
+	redirects, uerr = expandRedirects(
+		fsys, rules, aliases, oldFiles, oldSources, currentFiles, warn,
+	)
+	if uerr != nil {
+		return nil, nil, nil, uerr
+	}
+
+	redirects, uerr = resolveRedirects(fsys, redirects, oldFiles, currentFiles)
+	if uerr != nil {
+		return nil, nil, nil, uerr
+	}
+
+	if contains(formats, RFHtml) {
+		writeHtmlRedirects(fsys, root, redirects)
+		for _, r := range redirects {
+			generated = append(generated, r.Src)
+		}
+	} else {
+		for _, r := range redirects {
+			sources = append(sources, r.Src)
+		}
+	}
+
+	for _, name := range formats {
+		if name == RFHtml {
+			continue
+		}
+		format := redirectFormats[name]
+		err := fsys.WriteFile(format.Path, format.Render(root, redirects))
+		if err != nil {
+			panic(err)
+		}
+		generated = append(generated, format.Path)
+	}
+
+	return redirects, generated, sources, nil
+}
+
+// Unescaped web paths that should be redirected. A source that is an
+// index.html file is also reachable via its folder path, so redirect both.
+func (r *Redirect) webSources(root string) []string {
+	src := root + r.Src
+	if strings.HasSuffix(src, "/index.html") {
+		return []string{src, strings.TrimSuffix(src, "index.html")}
+	}
+	return []string{src}
+}
+
+func writeHtmlRedirects(fsys writablefs.FS, root string, redirects []Redirect) {
+	var generated []string
+	cleanUp := func() {
+		for _, path := range generated {
+			fsys.RemoveAll(path)
+		}
+	}
+	for _, r := range redirects {
+		srcDir := filepath.Dir(r.Src)
+		err := fsys.MkdirAll(srcDir)
+		if err != nil {
+			cleanUp()
+			panic(err)
+		}
+
+		var srcBuf bytes.Buffer
+		err = srcTmpl.Execute(&srcBuf, struct {
+			Url   string
+			Delay int
+		}{
+			Url:   r.webDest(root),
+			Delay: r.Delay,
+		})
+		if err != nil {
+			cleanUp()
+			panic(err)
+		}
+
+		err = fsys.WriteFile(r.Src, srcBuf.Bytes())
+		if err != nil {
+			cleanUp()
+			panic(err)
+		}
+
+		generated = append(generated, r.Src)
+	}
+}
+
+// Collect redirect rules, short circuit if any error found
+func parseRedirects(fsys writablefs.FS, path string) ([]Redirect, *errs.UserErr) {
 	f, err := fsys.Open(path)
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
 
-	var sources, dests []string
+	var rules []Redirect
 
-	// Collect redirects, short circuit if any error found
 	s := bufio.NewScanner(f)
 	lineNo := 0
 	for s.Scan() {
@@ -42,73 +262,359 @@ func generateRedirects(
 			}
 		}
 
+		rule := Redirect{File: path, Line: lineNo, Status: defaultRedirectStatus}
+
+		// Optional hints come after dest, separated by whitespace. Parse
+		// them from the end so that dest itself may contain spaces.
+		dest = strings.TrimSpace(dest)
+		for {
+			i := strings.LastIndexAny(dest, " \t")
+			if i == -1 {
+				break
+			}
+			hint := dest[i+1:]
+			if status, err := strconv.Atoi(hint); err == nil {
+				if !contains(redirectStatuses, hint) {
+					return nil, &errs.UserErr{
+						File: path,
+						Line: lineNo,
+						Msg: fmt.Sprintf(
+							`Invalid redirect status %d, expected one of: %s`,
+							status, strings.Join(redirectStatuses, ", "),
+						),
+					}
+				}
+				rule.Status = status
+			} else if val, ok := strings.CutPrefix(hint, "delay="); ok {
+				delay, err := strconv.Atoi(val)
+				if err != nil || delay < 0 {
+					return nil, &errs.UserErr{
+						File: path,
+						Line: lineNo,
+						Msg: fmt.Sprintf(
+							`Invalid delay "%s", expected a number of seconds`, val,
+						),
+					}
+				}
+				rule.Delay = delay
+			} else {
+				break
+			}
+			dest = strings.TrimSpace(dest[:i])
+		}
+
 		src = strings.TrimPrefix(strings.TrimSpace(src), "/")
-		dest = strings.TrimPrefix(strings.TrimSpace(dest), "/")
+		if !isExternalURL(dest) {
+			dest = strings.TrimPrefix(dest, "/")
+		}
 
-		if strings.HasSuffix(src, "/") {
+		srcStars := strings.Count(src, redirectWildcard)
+		destStars := strings.Count(dest, redirectWildcard)
+		if srcStars > 1 || destStars > 1 {
 			return nil, &errs.UserErr{
 				File: path,
 				Line: lineNo,
-				Msg:  fmt.Sprintf(`Source must not end with a "/" (found "%s")`, line),
+				Msg: fmt.Sprintf(
+					`Ambiguous pattern: only one "*" is allowed per side (found "%s")`,
+					line,
+				),
+			}
+		}
+		if srcStars == 0 && destStars == 1 {
+			return nil, &errs.UserErr{
+				File: path,
+				Line: lineNo,
+				Msg: fmt.Sprintf(
+					`Destination has a "*" but source doesn't (found "%s")`, line,
+				),
 			}
 		}
 
-		srcStat, err := fs.Stat(fsys, src)
-		if err == nil {
-			if srcStat.IsDir() {
+		if srcStars == 0 {
+			if strings.HasSuffix(src, "/") {
 				return nil, &errs.UserErr{
 					File: path,
 					Line: lineNo,
-					Msg:  fmt.Sprintf(`Source must not be a folder (found "%s")`, line),
+					Msg:  fmt.Sprintf(`Source must not end with a "/" (found "%s")`, line),
+				}
+			}
+
+			srcStat, err := fs.Stat(fsys, src)
+			if err == nil {
+				if srcStat.IsDir() {
+					return nil, &errs.UserErr{
+						File: path,
+						Line: lineNo,
+						Msg:  fmt.Sprintf(`Source must not be a folder (found "%s")`, line),
+					}
 				}
 			}
 		}
 
-		sources = append(sources, src)
-		dests = append(dests, dest)
+		rule.Src = src
+		rule.Dest = dest
+		rules = append(rules, rule)
 	}
 
-	// Actually generate html redirect files
-	cleanUp := func() {
-		for _, path := range generated {
-			fsys.RemoveAll(path)
+	return rules, nil
+}
+
+// Turns rules into concrete redirects. Exact rules and aliases are used
+// as-is, while wildcard rules are matched against files that were generated
+// last time (according to the manifest) but are no longer generated, and
+// against last time's redirect sources. This way moving a whole folder only
+// takes a single rule, and the resulting redirects stay in the manifest so
+// they keep being regenerated on subsequent runs.
+//
+// An exact rule always wins over wildcard rules, but a file matched by more
+// than one wildcard rule is considered an error. So is an exact source that
+// is defined twice, or that would overwrite a real file.
+func expandRedirects(
+	fsys writablefs.FS,
+	rules []Redirect,
+	aliases []Redirect,
+	oldFiles map[string]bool,
+	oldSources map[string]bool,
+	currentFiles map[string]bool,
+	warn func(*errs.UserErr),
+) ([]Redirect, *errs.UserErr) {
+	var redirects []Redirect
+	exactSources := make(map[string]string) // source => origin
+
+	for _, r := range append(aliases, rules...) {
+		if r.IsWildcard() {
+			continue
+		}
+		if prevOrigin, ok := exactSources[r.Src]; ok {
+			return nil, r.userErr(fmt.Sprintf(
+				`Duplicate source "%s", already defined in %s`,
+				r.Src, prevOrigin,
+			))
+		}
+
+		// Files that exist on disk but weren't generated by s4g last time
+		// are static files, which we must not overwrite.
+		if currentFiles[r.Src] ||
+			(!oldFiles[r.Src] && fileExists(fsys, r.Src)) {
+			return nil, r.userErr(fmt.Sprintf(
+				`Source "%s" is a real file, not a redirect`, r.Src,
+			))
+		}
+
+		exactSources[r.Src] = r.origin()
+		redirects = append(redirects, r)
+	}
+
+	candidates := make([]string, 0, len(oldFiles)+len(oldSources))
+	for f := range oldFiles {
+		if !currentFiles[f] {
+			candidates = append(candidates, f)
 		}
 	}
-	for i, src := range sources {
-		srcDir := filepath.Dir(src)
-		err := fsys.MkdirAll(srcDir)
-		if err != nil {
-			cleanUp()
-			panic(err)
+	for f := range oldSources {
+		if !currentFiles[f] && !oldFiles[f] {
+			candidates = append(candidates, f)
 		}
+	}
+	sort.Strings(candidates)
 
-		var srcBuf bytes.Buffer
-		err = srcTmpl.Execute(&srcBuf, root+dests[i])
-		if err != nil {
-			cleanUp()
-			panic(err)
+	wildcardSources := make(map[string]int)
+	for _, r := range rules {
+		if !r.IsWildcard() {
+			continue
 		}
 
-		err = fsys.WriteFile(src, srcBuf.Bytes())
-		if err != nil {
-			cleanUp()
-			panic(err)
+		numMatches := 0
+		for _, c := range candidates {
+			captured, ok := r.match(c)
+			if !ok {
+				continue
+			}
+			numMatches++
+
+			if _, ok := exactSources[c]; ok {
+				continue
+			}
+			if prevLine, ok := wildcardSources[c]; ok {
+				return nil, r.userErr(fmt.Sprintf(
+					`Ambiguous pattern: "%s" is also matched by line %d`,
+					c, prevLine,
+				))
+			}
+			wildcardSources[c] = r.Line
+
+			expanded := r
+			expanded.Src = c
+			expanded.Dest = strings.Replace(r.Dest, redirectWildcard, captured, 1)
+			redirects = append(redirects, expanded)
 		}
 
-		generated = append(generated, src)
+		if numMatches == 0 {
+			warn(r.userErr(fmt.Sprintf(
+				`"%s" doesn't match any previously generated file`, r.Src,
+			)))
+		}
+	}
+
+	return redirects, nil
+}
+
+// Makes sure every redirect ends up somewhere that actually exists, either a
+// file generated in this run or a static file. Files that were generated last
+// time but not this time are about to be deleted, so they don't count.
+// A redirect whose destination is another redirect's source is flattened so
+// that it points straight to the final destination.
+func resolveRedirects(
+	fsys writablefs.FS,
+	redirects []Redirect,
+	oldFiles map[string]bool,
+	currentFiles map[string]bool,
+) ([]Redirect, *errs.UserErr) {
+	bySource := make(map[string]int)
+	for i, r := range redirects {
+		bySource[r.Src] = i
+	}
+
+	// Finds the redirect whose source is the file that dest points to, if any.
+	findNext := func(dest string) (Redirect, bool) {
+		target := redirectTarget(dest)
+		if i, ok := bySource[target]; ok {
+			return redirects[i], true
+		}
+		if target == "" || strings.HasSuffix(target, "/") {
+			if i, ok := bySource[target+"index.html"]; ok {
+				return redirects[i], true
+			}
+		}
+		return Redirect{}, false
+	}
+
+	resolved := make([]Redirect, len(redirects))
+	for i, r := range redirects {
+		resolved[i] = r
+		if r.IsExternal() {
+			continue
+		}
+
+		chain := []string{r.Src}
+		seen := map[string]bool{r.Src: true}
+		dest := r.Dest
+		for {
+			next, ok := findNext(dest)
+			if !ok {
+				break
+			}
+			chain = append(chain, next.Src)
+			if seen[next.Src] {
+				return nil, r.userErr(fmt.Sprintf(
+					"Redirect loop: %s", strings.Join(chain, " -> "),
+				))
+			}
+			seen[next.Src] = true
+			dest = next.Dest
+			if next.IsExternal() {
+				break
+			}
+		}
+
+		if !isExternalURL(dest) && !redirectDestExists(fsys, dest, oldFiles, currentFiles) {
+			msg := fmt.Sprintf(`Destination "%s" does not exist`, dest)
+			if len(chain) > 1 {
+				msg += fmt.Sprintf(
+					" (followed redirects: %s)", strings.Join(chain, " -> "),
+				)
+			}
+			return nil, r.userErr(msg)
+		}
+
+		resolved[i].Dest = dest
+	}
+
+	return resolved, nil
+}
+
+// Turns articles' Aliases into redirects to the articles themselves.
+// Drafts are skipped since they aren't published.
+func articleAliases(articles map[string]*Article) ([]Redirect, *errs.UserErr) {
+	paths := make([]string, 0, len(articles))
+	for p := range articles {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var aliases []Redirect
+	for _, p := range paths {
+		a := articles[p]
+		if a.IsDraft {
+			continue
+		}
+		for _, alias := range a.Aliases {
+			if alias == "" {
+				continue
+			}
+			r := Redirect{
+				Src:    strings.TrimPrefix(alias, "/"),
+				Dest:   strings.TrimSuffix(a.OutputPath, "index.html"),
+				File:   a.Path,
+				Field:  "Aliases",
+				Status: defaultRedirectStatus,
+			}
+			// Aliases are usually copied from old URLs, so a folder is
+			// understood as its index page.
+			if r.Src == "" || strings.HasSuffix(r.Src, "/") {
+				r.Src += "index.html"
+			}
+			if strings.Contains(alias, redirectWildcard) || isExternalURL(alias) {
+				return nil, r.userErr(fmt.Sprintf(
+					`"%s" is not a valid alias, expected a path in this website`,
+					alias,
+				))
+			}
+			aliases = append(aliases, r)
+		}
+	}
+	return aliases, nil
+}
+
+// Strips query string and fragment from dest, leaving the file path.
+func redirectTarget(dest string) string {
+	if i := strings.IndexAny(dest, "?#"); i != -1 {
+		dest = dest[:i]
+	}
+	return dest
+}
+
+// Whether dest points to a file generated in this run or a static file.
+// Files in oldFiles but not in currentFiles are stale generated files that
+// are about to be deleted, so they don't count even if still on disk.
+func redirectDestExists(
+	fsys writablefs.FS, dest string, oldFiles, currentFiles map[string]bool,
+) bool {
+	target := redirectTarget(dest)
+	if target == "" || strings.HasSuffix(target, "/") {
+		target += "index.html"
+	}
+	exists := func(p string) bool {
+		return currentFiles[p] || (!oldFiles[p] && fileExists(fsys, p))
+	}
+	if exists(target) {
+		return true
 	}
 
-	return generated, nil
+	// A folder without trailing slash, which most hosts redirect to the
+	// slashed version anyway.
+	stat, err := fs.Stat(fsys, target)
+	return err == nil && stat.IsDir() && exists(target+"/index.html")
 }
 
 var srcTmpl = template.Must(template.New("src").Parse(`<!DOCTYPE html>
 <html lang="en">
   <head>
-    <title>Redirecting to {{.}}</title>
-    <meta http-equiv="Refresh" content="0; URL={{.}}" />
+    <title>Redirecting to {{.Url}}</title>
+    <meta http-equiv="Refresh" content="{{.Delay}}; URL={{.Url}}" />
   </head>
   <body>
-    The page you're looking for has been moved to <a href="{{.}}">{{.}}</a>.
+    The page you're looking for has been moved to <a href="{{.Url}}">{{.Url}}</a>.
   </body>
 </html>
 `))
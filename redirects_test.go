@@ -0,0 +1,510 @@
+package main
+
+import (
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.imnhan.com/s4g/errs"
+	"go.imnhan.com/s4g/writablefs"
+)
+
+func TestParseRedirects(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		RedirectsPath: "# comment\n" +
+			"\n" +
+			"old.html -> new.html\n" +
+			"  /with/slashes.html  ->  /new/  \n" +
+			"old/blog/* -> posts/*\n" +
+			"old/*.html -> new/*/\n" +
+			"gone/* -> archive.html\n",
+	})
+
+	rules, uerr := parseRedirects(fsys, RedirectsPath)
+	if uerr != nil {
+		t.Fatal(uerr)
+	}
+	rule := func(line int, src, dest string) Redirect {
+		return Redirect{
+			Src: src, Dest: dest,
+			File: RedirectsPath, Line: line,
+			Status: defaultRedirectStatus,
+		}
+	}
+	want := []Redirect{
+		rule(3, "old.html", "new.html"),
+		rule(4, "with/slashes.html", "new/"),
+		rule(5, "old/blog/*", "posts/*"),
+		rule(6, "old/*.html", "new/*/"),
+		rule(7, "gone/*", "archive.html"),
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("got  %+v\nwant %+v", rules, want)
+	}
+}
+
+func TestParseRedirectsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantMsg string
+	}{
+		{"missing arrow", "a.html b.html", `Expected "src -> dest"`},
+		{"two stars in source", "a/*/*.html -> b/*", `only one "*" is allowed`},
+		{"two stars in dest", "a/* -> b/*/*", `only one "*" is allowed`},
+		{"star only in dest", "a.html -> b/*", `Destination has a "*" but source doesn't`},
+		{"source ends with slash", "a/ -> b.html", `must not end with a "/"`},
+		{"source is a folder", "folder -> b.html", `must not be a folder`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newTestFS(t, map[string]string{
+				RedirectsPath:   "ok.html -> b.html\n" + tt.content + "\n",
+				"folder/a.html": "",
+			})
+			rules, uerr := parseRedirects(fsys, RedirectsPath)
+			if uerr == nil {
+				t.Fatalf("expected error, got %+v", rules)
+			}
+			if uerr.Line != 2 || !strings.Contains(uerr.Msg, tt.wantMsg) {
+				t.Errorf("got line %d %q, want line 2 %q", uerr.Line, uerr.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestExpandRedirects(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		"static.html":        "",
+		"old/blog/kept.html": "",
+	})
+	oldFiles := map[string]bool{
+		"old/blog/a.html":       true,
+		"old/blog/b/index.html": true,
+		"old/blog/kept.html":    true,
+		"old/other.html":        true,
+		"feed.xml":              true,
+	}
+	oldSources := map[string]bool{
+		"older/blog/c.html": true,
+	}
+	currentFiles := map[string]bool{
+		"old/blog/kept.html": true,
+		"feed.xml":           true,
+	}
+	rules := []Redirect{
+		{Src: "old/blog/*", Dest: "posts/*", Line: 1},
+		{Src: "old/blog/a.html", Dest: "special.html", Line: 2},
+		{Src: "older/*", Dest: "posts/*", Line: 3},
+		{Src: "nothing/*", Dest: "posts/*", Line: 4},
+	}
+	aliases := []Redirect{
+		{Src: "alias.html", Dest: "posts/", Field: "Aliases"},
+	}
+
+	var warnings []*errs.UserErr
+	redirects, uerr := expandRedirects(
+		fsys, rules, aliases, oldFiles, oldSources, currentFiles,
+		func(w *errs.UserErr) { warnings = append(warnings, w) },
+	)
+	if uerr != nil {
+		t.Fatal(uerr)
+	}
+
+	got := make(map[string]string)
+	for _, r := range redirects {
+		got[r.Src] = r.Dest
+	}
+	want := map[string]string{
+		"alias.html":            "posts/",
+		"old/blog/a.html":       "special.html",
+		"old/blog/b/index.html": "posts/b/index.html",
+		"older/blog/c.html":     "posts/blog/c.html",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+
+	if len(warnings) != 1 || warnings[0].Line != 4 {
+		t.Errorf("expected 1 warning for line 4, got %v", warnings)
+	}
+}
+
+func TestExpandRedirectsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Redirect
+		wantMsg string
+	}{
+		{
+			name: "ambiguous wildcards",
+			rules: []Redirect{
+				{Src: "old/*", Dest: "a/*", Line: 1},
+				{Src: "old/*.html", Dest: "b/*", Line: 2},
+			},
+			wantMsg: `Ambiguous pattern: "old/a.html" is also matched by line 1`,
+		},
+		{
+			name: "duplicate source",
+			rules: []Redirect{
+				{Src: "x.html", Dest: "a.html", Line: 1},
+				{Src: "x.html", Dest: "b.html", Line: 2},
+			},
+			wantMsg: `Duplicate source "x.html"`,
+		},
+		{
+			name:    "static file",
+			rules:   []Redirect{{Src: "static.html", Dest: "a.html", Line: 1}},
+			wantMsg: `Source "static.html" is a real file`,
+		},
+		{
+			name:    "currently generated file",
+			rules:   []Redirect{{Src: "current.html", Dest: "a.html", Line: 1}},
+			wantMsg: `Source "current.html" is a real file`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newTestFS(t, map[string]string{
+				"static.html":  "",
+				"current.html": "",
+			})
+			_, uerr := expandRedirects(
+				fsys, tt.rules, nil,
+				map[string]bool{"old/a.html": true, "current.html": true},
+				nil,
+				map[string]bool{"current.html": true},
+				func(*errs.UserErr) {},
+			)
+			if uerr == nil || !strings.Contains(uerr.Msg, tt.wantMsg) {
+				t.Errorf("got %v, want %q", uerr, tt.wantMsg)
+			}
+		})
+	}
+
+	// A file generated last time but not this time may become a redirect
+	fsys := newTestFS(t, map[string]string{"stale.html": ""})
+	_, uerr := expandRedirects(
+		fsys, []Redirect{{Src: "stale.html", Dest: "a.html"}}, nil,
+		map[string]bool{"stale.html": true}, nil, nil,
+		func(*errs.UserErr) {},
+	)
+	if uerr != nil {
+		t.Errorf("unexpected error: %v", uerr)
+	}
+}
+
+func TestResolveRedirects(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		"static.html":       "",
+		"folder/index.html": "",
+		"stale.html":        "",
+		"generated/a.html":  "",
+	})
+	oldFiles := map[string]bool{"stale.html": true, "generated/a.html": true}
+	currentFiles := map[string]bool{"generated/a.html": true}
+
+	tests := []struct {
+		name      string
+		redirects []Redirect
+		want      []string // resolved dests
+		wantMsg   string
+	}{
+		{
+			name: "existing destinations",
+			redirects: []Redirect{
+				{Src: "a.html", Dest: "static.html"},
+				{Src: "b.html", Dest: "folder/"},
+				{Src: "c.html", Dest: "folder"},
+				{Src: "d.html", Dest: "generated/a.html?x=1#top"},
+				{Src: "e.html", Dest: "https://example.com/"},
+			},
+			want: []string{
+				"static.html", "folder/", "folder",
+				"generated/a.html?x=1#top", "https://example.com/",
+			},
+		},
+		{
+			name: "chain is flattened",
+			redirects: []Redirect{
+				{Src: "a.html", Dest: "b.html"},
+				{Src: "b.html", Dest: "c/"},
+				{Src: "c/index.html", Dest: "static.html"},
+			},
+			want: []string{"static.html", "static.html", "static.html"},
+		},
+		{
+			name: "chain to external URL",
+			redirects: []Redirect{
+				{Src: "a.html", Dest: "b.html"},
+				{Src: "b.html", Dest: "//example.com/b"},
+			},
+			want: []string{"//example.com/b", "//example.com/b"},
+		},
+		{
+			name: "loop",
+			redirects: []Redirect{
+				{Src: "a.html", Dest: "b.html"},
+				{Src: "b.html", Dest: "a.html"},
+			},
+			wantMsg: "Redirect loop: a.html -> b.html -> a.html",
+		},
+		{
+			name:      "missing destination",
+			redirects: []Redirect{{Src: "a.html", Dest: "missing.html"}},
+			wantMsg:   `Destination "missing.html" does not exist`,
+		},
+		{
+			name: "missing destination at end of chain",
+			redirects: []Redirect{
+				{Src: "a.html", Dest: "b.html"},
+				{Src: "b.html", Dest: "missing.html"},
+			},
+			wantMsg: "(followed redirects: a.html -> b.html)",
+		},
+		{
+			name:      "stale generated file",
+			redirects: []Redirect{{Src: "a.html", Dest: "stale.html"}},
+			wantMsg:   `Destination "stale.html" does not exist`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, uerr := resolveRedirects(fsys, tt.redirects, oldFiles, currentFiles)
+			if tt.wantMsg != "" {
+				if uerr == nil || !strings.Contains(uerr.Msg, tt.wantMsg) {
+					t.Errorf("got %v, want %q", uerr, tt.wantMsg)
+				}
+				return
+			}
+			if uerr != nil {
+				t.Fatal(uerr)
+			}
+			var dests []string
+			for _, r := range resolved {
+				dests = append(dests, r.Dest)
+			}
+			if !reflect.DeepEqual(dests, tt.want) {
+				t.Errorf("got %v, want %v", dests, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsExternalURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/a":  true,
+		"HTTP://example.com":     true,
+		"//example.com/a":        true,
+		"posts/a.html":           false,
+		"/posts/a.html":          false,
+		"a:b.html":               false,
+		"mailto:me@example.com":  false,
+		"javascript:alert(1)":    false,
+		"ftp://example.com/file": false,
+		"":                       false,
+	}
+	for in, want := range tests {
+		if got := isExternalURL(in); got != want {
+			t.Errorf("isExternalURL(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestParseRedirectHints(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Redirect
+		wantMsg string
+	}{
+		{
+			line: "a.html -> b.html 302",
+			want: Redirect{Src: "a.html", Dest: "b.html", Status: 302},
+		},
+		{
+			line: "a.html -> b.html delay=5",
+			want: Redirect{Src: "a.html", Dest: "b.html", Status: 301, Delay: 5},
+		},
+		{
+			line: "a.html -> https://example.com/x 308\tdelay=0",
+			want: Redirect{Src: "a.html", Dest: "https://example.com/x", Status: 308},
+		},
+		{
+			line: "a.html -> my file.html delay=2 307",
+			want: Redirect{Src: "a.html", Dest: "my file.html", Status: 307, Delay: 2},
+		},
+		{
+			line: "a.html -> /a:b.html",
+			want: Redirect{Src: "a.html", Dest: "a:b.html", Status: 301},
+		},
+		{
+			line: "a.html -> //example.com/x",
+			want: Redirect{Src: "a.html", Dest: "//example.com/x", Status: 301},
+		},
+		{line: "a.html -> b.html 200", wantMsg: "Invalid redirect status 200"},
+		{line: "a.html -> b.html delay=-1", wantMsg: `Invalid delay "-1"`},
+		{line: "a.html -> b.html delay=soon", wantMsg: `Invalid delay "soon"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			fsys := newTestFS(t, map[string]string{RedirectsPath: tt.line})
+			rules, uerr := parseRedirects(fsys, RedirectsPath)
+			if tt.wantMsg != "" {
+				if uerr == nil || !strings.Contains(uerr.Msg, tt.wantMsg) {
+					t.Errorf("got %v, want %q", uerr, tt.wantMsg)
+				}
+				return
+			}
+			if uerr != nil {
+				t.Fatal(uerr)
+			}
+			tt.want.File = RedirectsPath
+			tt.want.Line = 1
+			if len(rules) != 1 || rules[0] != tt.want {
+				t.Errorf("got %+v, want %+v", rules, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateRedirects(t *testing.T) {
+	newFS := func(t *testing.T, extra map[string]string) writablefs.FS {
+		files := map[string]string{
+			RedirectsPath:    "old/post.html -> new/ 302\n",
+			"new/index.html": "",
+		}
+		for p, c := range extra {
+			files[p] = c
+		}
+		return newTestFS(t, files)
+	}
+	currentFiles := map[string]bool{"new/index.html": true}
+	noWarn := func(w *errs.UserErr) { t.Errorf("unexpected warning: %v", w) }
+
+	t.Run("html", func(t *testing.T) {
+		fsys := newFS(t, nil)
+		_, generated, sources, uerr := generateRedirects(
+			fsys, RedirectsPath, "/blog/", []string{RFHtml}, nil,
+			nil, nil, currentFiles, noWarn,
+		)
+		if uerr != nil {
+			t.Fatal(uerr)
+		}
+		if !reflect.DeepEqual(generated, []string{"old/post.html"}) || sources != nil {
+			t.Errorf("generated = %v, sources = %v", generated, sources)
+		}
+		page, _ := fs.ReadFile(fsys, "old/post.html")
+		if !strings.Contains(string(page), `content="0; URL=/blog/new/"`) {
+			t.Errorf("unexpected redirect page:\n%s", page)
+		}
+	})
+
+	t.Run("host config only", func(t *testing.T) {
+		fsys := newFS(t, nil)
+		_, generated, sources, uerr := generateRedirects(
+			fsys, RedirectsPath, "/blog/", []string{"netlify"}, nil,
+			nil, nil, currentFiles, noWarn,
+		)
+		if uerr != nil {
+			t.Fatal(uerr)
+		}
+		// Sources aren't generated files, so a leftover meta refresh page
+		// gets deleted instead of shadowing the host's redirect.
+		if !reflect.DeepEqual(generated, []string{"_redirects"}) ||
+			!reflect.DeepEqual(sources, []string{"old/post.html"}) {
+			t.Errorf("generated = %v, sources = %v", generated, sources)
+		}
+		if fileExists(fsys, "old/post.html") {
+			t.Error("html redirect page was written")
+		}
+		config, _ := fs.ReadFile(fsys, "_redirects")
+		want := redirectConfigHeader + "/blog/old/post.html /blog/new/ 302\n"
+		if string(config) != want {
+			t.Errorf("_redirects = %q, want %q", config, want)
+		}
+	})
+
+	t.Run("hand-written config", func(t *testing.T) {
+		fsys := newFS(t, map[string]string{".htaccess": "DirectoryIndex index.html\n"})
+		_, _, _, uerr := generateRedirects(
+			fsys, RedirectsPath, "/", []string{RFHtml, "apache"}, nil,
+			nil, nil, currentFiles, noWarn,
+		)
+		if uerr == nil || !strings.Contains(uerr.Msg, ".htaccess already exists") {
+			t.Errorf("got %v", uerr)
+		}
+		content, _ := fs.ReadFile(fsys, ".htaccess")
+		if string(content) != "DirectoryIndex index.html\n" {
+			t.Errorf(".htaccess was overwritten: %q", content)
+		}
+	})
+
+	t.Run("config generated last time", func(t *testing.T) {
+		fsys := newFS(t, map[string]string{".htaccess": "old"})
+		_, _, _, uerr := generateRedirects(
+			fsys, RedirectsPath, "/", []string{"apache"}, nil,
+			map[string]bool{".htaccess": true}, nil, currentFiles, noWarn,
+		)
+		if uerr != nil {
+			t.Fatal(uerr)
+		}
+		content, _ := fs.ReadFile(fsys, ".htaccess")
+		if !strings.HasPrefix(string(content), redirectConfigHeader) {
+			t.Errorf(".htaccess wasn't regenerated: %q", content)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		fsys := newFS(t, nil)
+		_, _, _, uerr := generateRedirects(
+			fsys, RedirectsPath, "/", []string{"iis"}, nil,
+			nil, nil, currentFiles, noWarn,
+		)
+		if uerr == nil || uerr.Field != "RedirectFormats" {
+			t.Errorf("got %v", uerr)
+		}
+	})
+}
+
+func TestArticleAliases(t *testing.T) {
+	article := func(path, outputPath string, isDraft bool, aliases ...string) *Article {
+		a := &Article{Path: path, OutputPath: outputPath}
+		a.IsDraft = isDraft
+		a.Aliases = aliases
+		return a
+	}
+	articles := map[string]*Article{
+		"b.dj":       article("b.dj", "b.html", false, "/old-b.html", "old/b/", ""),
+		"a/index.dj": article("a/index.dj", "a/index.html", false, "old-a.html"),
+		"draft.dj":   article("draft.dj", "draft.html", true, "old-draft.html"),
+	}
+
+	aliases, uerr := articleAliases(articles)
+	if uerr != nil {
+		t.Fatal(uerr)
+	}
+	alias := func(src, dest, file string) Redirect {
+		return Redirect{
+			Src: src, Dest: dest, File: file, Field: "Aliases",
+			Status: defaultRedirectStatus,
+		}
+	}
+	want := []Redirect{
+		alias("old-a.html", "a/", "a/index.dj"),
+		alias("old-b.html", "b.html", "b.dj"),
+		alias("old/b/index.html", "b.html", "b.dj"),
+	}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Errorf("got  %+v\nwant %+v", aliases, want)
+	}
+
+	for _, invalid := range []string{"old/*", "https://example.com/a"} {
+		_, uerr := articleAliases(map[string]*Article{
+			"a.dj": article("a.dj", "a.html", false, invalid),
+		})
+		if uerr == nil || uerr.File != "a.dj" || uerr.Field != "Aliases" {
+			t.Errorf("alias %q: got %v", invalid, uerr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"go.imnhan.com/s4g/djot"
+	"go.imnhan.com/s4g/errs"
+)
+
+// Each shortcode is a template file in this folder, e.g. a shortcode named
+// "youtube" is rendered by "youtube.tmpl".
+var ShortcodesPath = ThemePath + "/shortcodes"
+
+var shortcodeNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Shortcode templates, parsed on first use and shared by all articles.
+type shortcodeSet struct {
+	fsys      fs.FS
+	funcs     template.FuncMap
+	templates map[string]*template.Template
+}
+
+func newShortcodeSet(fsys fs.FS, funcs template.FuncMap) *shortcodeSet {
+	return &shortcodeSet{
+		fsys:      fsys,
+		funcs:     funcs,
+		templates: make(map[string]*template.Template),
+	}
+}
+
+func (s *shortcodeSet) get(name string) (*template.Template, error) {
+	if tmpl, ok := s.templates[name]; ok {
+		return tmpl, nil
+	}
+	if !shortcodeNameRegex.MatchString(name) {
+		return nil, fmt.Errorf(
+			`invalid shortcode name "%s": only letters, digits, "-" and "_" are allowed`,
+			name,
+		)
+	}
+	path := ShortcodesPath + "/" + name + ".tmpl"
+	if !fileExists(s.fsys, path) {
+		return nil, fmt.Errorf(`unknown shortcode "%s": %s not found`, name, path)
+	}
+	tmpl, err := template.New(name+".tmpl").Funcs(s.funcs).ParseFS(s.fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf(`shortcode "%s": %w`, name, err)
+	}
+	s.templates[name] = tmpl
+	return tmpl, nil
+}
+
+// Replaces shortcode markers left by the djot service with the output of
+// their templates. Nested shortcodes come first, so by the time a shortcode
+// is executed, its content is already expanded.
+func (a *Article) expandShortcodes(
+	content string, calls []djot.Shortcode, shortcodes *shortcodeSet,
+) (string, error) {
+	for i, call := range calls {
+		start := fmt.Sprintf("<!--s4g-shortcode:%d-->", i)
+		end := fmt.Sprintf("<!--/s4g-shortcode:%d-->", i)
+		startIdx := strings.Index(content, start)
+		endIdx := strings.Index(content, end)
+		if startIdx == -1 || endIdx < startIdx {
+			continue
+		}
+		inner := content[startIdx+len(start) : endIdx]
+
+		var buf bytes.Buffer
+		tmpl, err := shortcodes.get(call.Name)
+		if err == nil {
+			err = tmpl.Execute(&buf, struct {
+				Args    map[string]string
+				Content template.HTML
+				Post    *Article
+			}{
+				Args:    call.Args,
+				Content: template.HTML(strings.TrimSpace(inner)),
+				Post:    a,
+			})
+		}
+		if err != nil {
+			uerr := &errs.UserErr{File: a.Path, Msg: err.Error()}
+			if call.Line != 0 {
+				uerr.Line = a.fileLine(call.Line)
+			}
+			return "", uerr
+		}
+
+		// Template files usually end with a newline, which shouldn't end up
+		// in inline shortcodes.
+		output := strings.TrimSuffix(buf.String(), "\n")
+		content = content[:startIdx] + output + content[endIdx+len(end):]
+	}
+	return content, nil
+}
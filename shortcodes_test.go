@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.imnhan.com/s4g/djot"
+	"go.imnhan.com/s4g/errs"
+)
+
+func TestExpandShortcodes(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		ShortcodesPath + "/figure.tmpl": `<figure class="{{.Args.class}}">{{.Content}}</figure>` + "\n",
+		ShortcodesPath + "/em.tmpl":     `<em>{{.Content}}</em>` + "\n",
+		ShortcodesPath + "/title.tmpl":  `{{.Post.Title}}`,
+	})
+	set := newShortcodeSet(fsys, nil)
+	a := &Article{Path: "a.dj", BodyLine: 3}
+	a.Title = "Hello"
+
+	content := "<h1>Hi <!--s4g-shortcode:2--><!--/s4g-shortcode:2--></h1>\n" +
+		"<!--s4g-shortcode:1-->\n" +
+		"<p>Hello <!--s4g-shortcode:0-->world<!--/s4g-shortcode:0--></p>\n" +
+		"<!--/s4g-shortcode:1-->\n"
+	calls := []djot.Shortcode{
+		{Name: "em"},
+		{Name: "figure", Args: map[string]string{"class": "wide"}},
+		{Name: "title"},
+	}
+
+	got, err := a.expandShortcodes(content, calls, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<h1>Hi Hello</h1>\n" +
+		`<figure class="wide"><p>Hello <em>world</em></p></figure>` + "\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExpandShortcodesErrors(t *testing.T) {
+	fsys := newTestFS(t, map[string]string{
+		ShortcodesPath + "/broken.tmpl": `{{.Args.x`,
+	})
+	tests := []struct {
+		name    string
+		wantMsg string
+	}{
+		{"missing", `unknown shortcode "missing"`},
+		{"../escape", `invalid shortcode name "../escape"`},
+		{"broken", `shortcode "broken"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Article{Path: "a.dj", BodyLine: 3}
+			_, err := a.expandShortcodes(
+				"<!--s4g-shortcode:0-->x<!--/s4g-shortcode:0-->",
+				[]djot.Shortcode{{Name: tt.name, Line: 2}},
+				newShortcodeSet(fsys, nil),
+			)
+			uerr, ok := err.(*errs.UserErr)
+			if !ok || !strings.Contains(uerr.Msg, tt.wantMsg) {
+				t.Fatalf("got %v, want %q", err, tt.wantMsg)
+			}
+			// Line 2 of the body, which starts at line 3 of the file
+			if uerr.File != "a.dj" || uerr.Line != 4 {
+				t.Errorf("got %s line %d, want a.dj line 4", uerr.File, uerr.Line)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A line with only this marker separates an article's summary from the rest
+// of its content.
+const summaryMarker = "<!--more-->"
+
+// Djot would escape a bare html comment, so the marker is turned into a raw
+// html paragraph before rendering, and looked for in the rendered output.
+const summaryMarkerDjot = "`<!--s4g-more-->`{=html}"
+const summaryMarkerHtml = "<!--s4g-more-->"
+
+// Replaces summary marker lines outside of code blocks with something that
+// survives djot rendering. Line count stays the same.
+func markSummaryBreak(body []byte) []byte {
+	var fence string
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if f := codeFence(trimmed); f != "" {
+			if fence == "" {
+				fence = f
+			} else if strings.HasPrefix(f, fence) && strings.TrimSpace(trimmed) == f {
+				fence = ""
+			}
+		}
+		if fence == "" && strings.TrimSpace(trimmed) == summaryMarker {
+			// Indentation is kept, e.g. for a marker inside a list item
+			indent := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, " \t"))]
+			lines[i] = indent + summaryMarkerDjot + line[len(trimmed):]
+		}
+	}
+	return []byte(strings.Join(lines, ""))
+}
+
+// Splits ContentHtml at the summary marker, if any. Otherwise, if
+// summaryWords is positive, the summary is made of as many leading blocks
+// as needed to reach that many words.
+//
+// The marker is its own paragraph when surrounded by blank lines, but djot
+// puts it in the surrounding paragraph or list item otherwise. Either way,
+// the summary is everything before it, with unclosed elements closed.
+//
+// Links in the summary are rewritten to be relative to the website's root,
+// because it's meant to be shown on other pages.
+func (a *Article) ComputeSummary(root string, summaryWords int) {
+	content := string(a.ContentHtml)
+	before, after, found := strings.Cut(content, "<p>"+summaryMarkerHtml+"</p>\n")
+	summarySrc := before
+	if !found {
+		before, after, found = strings.Cut(content, summaryMarkerHtml)
+		after = strings.TrimPrefix(after, "\n")
+		summarySrc = strings.TrimRight(before, " \n")
+	}
+
+	var nodes []*html.Node
+	if found {
+		a.ContentHtml = template.HTML(before + after)
+		nodes = parseHtmlFragment(summarySrc)
+	} else if summaryWords > 0 {
+		nodes = leadingBlocks(parseHtmlFragment(content), summaryWords)
+	} else {
+		return
+	}
+
+	base := &url.URL{Path: root + a.OutputPath}
+	a.Summary = template.HTML(renderWithBase(nodes, base))
+}
+
+// Resolves links in an html fragment against base.
+func resolveLinks(fragment string, base *url.URL) string {
+	return renderWithBase(parseHtmlFragment(fragment), base)
+}
+
+func renderWithBase(nodes []*html.Node, base *url.URL) string {
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		rewriteLinks(n, base)
+		html.Render(&buf, n)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// Parses partial html, closing any unclosed elements.
+func parseHtmlFragment(s string) []*html.Node {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		panic(err)
+	}
+	return nodes
+}
+
+// Returns leading block nodes until they contain at least numWords words.
+// Sections are unwrapped, because djot puts everything after a heading
+// into one.
+func leadingBlocks(nodes []*html.Node, numWords int) []*html.Node {
+	var result []*html.Node
+	words := 0
+	var walk func(nodes []*html.Node) bool
+	walk = func(nodes []*html.Node) (done bool) {
+		for _, n := range nodes {
+			if n.Type == html.ElementNode && n.DataAtom == atom.Section {
+				var children []*html.Node
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					children = append(children, c)
+				}
+				if walk(children) {
+					return true
+				}
+				continue
+			}
+			if n.Type == html.TextNode && strings.TrimSpace(n.Data) == "" {
+				continue
+			}
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+			result = append(result, n)
+			words += len(strings.Fields(textContent(n)))
+			if words >= numWords {
+				return true
+			}
+		}
+		return false
+	}
+	walk(nodes)
+	return result
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+// Resolves relative href, src and srcset urls against base.
+func rewriteLinks(n *html.Node, base *url.URL) {
+	resolve := func(link string) string {
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "" || u.Host != "" {
+			return link
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			switch attr.Key {
+			case "href", "src":
+				n.Attr[i].Val = resolve(attr.Val)
+			case "srcset":
+				candidates := strings.Split(attr.Val, ",")
+				for j, c := range candidates {
+					fields := strings.Fields(c)
+					if len(fields) > 0 {
+						fields[0] = resolve(fields[0])
+						candidates[j] = strings.Join(fields, " ")
+					}
+				}
+				n.Attr[i].Val = strings.Join(candidates, ", ")
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		rewriteLinks(c, base)
+	}
+}
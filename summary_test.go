@@ -0,0 +1,98 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestMarkSummaryBreak(t *testing.T) {
+	body := "Intro\n" +
+		"<!--more-->\n" +
+		"```\n" +
+		"<!--more-->\n" +
+		"```\n" +
+		"  <!--more-->\r\n" +
+		"Not <!--more--> alone\n"
+	want := "Intro\n" +
+		summaryMarkerDjot + "\n" +
+		"```\n" +
+		"<!--more-->\n" +
+		"```\n" +
+		"  " + summaryMarkerDjot + "\r\n" +
+		"Not <!--more--> alone\n"
+	if got := string(markSummaryBreak([]byte(body))); got != want {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestComputeSummary(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		summaryWords int
+		wantSummary  string
+		wantContent  string
+	}{
+		{
+			name: "marker",
+			content: `<p>First <a href="b.html">link</a> <img src="/blog/cat.jpg" srcset="cat-480w.jpg 480w, https://example.com/x.jpg 2x"></p>` + "\n" +
+				"<p><!--s4g-more--></p>\n" +
+				"<p>Rest</p>\n",
+			summaryWords: 1,
+			wantSummary: `<p>First <a href="/blog/posts/b.html">link</a> ` +
+				`<img src="/blog/cat.jpg" srcset="/blog/posts/cat-480w.jpg 480w, https://example.com/x.jpg 2x"/></p>`,
+			wantContent: `<p>First <a href="b.html">link</a> <img src="/blog/cat.jpg" srcset="cat-480w.jpg 480w, https://example.com/x.jpg 2x"></p>` + "\n" +
+				"<p>Rest</p>\n",
+		},
+		{
+			name: "marker inside section",
+			content: "<section id=\"a\">\n<h1>A</h1>\n<p>Intro <a href=\"#a\">here</a></p>\n" +
+				"<p><!--s4g-more--></p>\n" +
+				"<p>Rest</p>\n</section>\n",
+			wantSummary: "<section id=\"a\">\n<h1>A</h1>\n<p>Intro <a href=\"/blog/posts/a.html#a\">here</a></p>\n</section>",
+			wantContent: "<section id=\"a\">\n<h1>A</h1>\n<p>Intro <a href=\"#a\">here</a></p>\n" +
+				"<p>Rest</p>\n</section>\n",
+		},
+		{
+			name:        "marker inside paragraph",
+			content:     "<p>Intro\n<!--s4g-more-->\nRest</p>\n",
+			wantSummary: "<p>Intro</p>",
+			wantContent: "<p>Intro\nRest</p>\n",
+		},
+		{
+			name: "marker inside list item",
+			content: "<p>Intro</p>\n<ul>\n<li>\nOne\n<!--s4g-more-->\n</li>\n" +
+				"<li>\nTwo\n</li>\n</ul>\n",
+			wantSummary: "<p>Intro</p>\n<ul>\n<li>\nOne</li></ul>",
+			wantContent: "<p>Intro</p>\n<ul>\n<li>\nOne\n</li>\n" +
+				"<li>\nTwo\n</li>\n</ul>\n",
+		},
+		{
+			name: "word count",
+			content: "<section id=\"a\">\n<h1>A title</h1>\n<p>One two three</p>\n" +
+				"<p>Four five</p>\n<p>Six</p>\n</section>\n",
+			summaryWords: 5,
+			wantSummary:  "<h1>A title</h1><p>One two three</p>",
+			wantContent: "<section id=\"a\">\n<h1>A title</h1>\n<p>One two three</p>\n" +
+				"<p>Four five</p>\n<p>Six</p>\n</section>\n",
+		},
+		{
+			name:        "no summary",
+			content:     "<p>One two three</p>\n",
+			wantSummary: "",
+			wantContent: "<p>One two three</p>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Article{OutputPath: "posts/a.html", ContentHtml: template.HTML(tt.content)}
+			a.ComputeSummary("/blog/", tt.summaryWords)
+			if string(a.Summary) != tt.wantSummary {
+				t.Errorf("summary:\n%s\nwant:\n%s", a.Summary, tt.wantSummary)
+			}
+			if string(a.ContentHtml) != tt.wantContent {
+				t.Errorf("content:\n%s\nwant:\n%s", a.ContentHtml, tt.wantContent)
+			}
+		})
+	}
+}
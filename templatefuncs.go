@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Functions available to every theme, custom and shortcode template.
+// Keep README's list in sync when changing these.
+func templateFuncs(site *SiteMetadata, fsys fs.FS) template.FuncMap {
+	return template.FuncMap{
+		"absURL":   func(p string) string { return absURL(site, p) },
+		"relURL":   func(p string) string { return relURL(site, p) },
+		"themeURL": func(name string) string { return themeURL(site, fsys, name) },
+
+		"formatDate": formatDate,
+		"truncate":   truncate,
+		"plainify":   plainify,
+		"escapeDjot": escapeDjot,
+		"safeHTML":   func(s string) template.HTML { return template.HTML(s) },
+		"safeURL":    func(s string) template.URL { return template.URL(s) },
+
+		"first":  first,
+		"last":   last,
+		"after":  after,
+		"where":  where,
+		"sortBy": sortBy,
+		"dict":   dict,
+	}
+}
+
+// Turns a path relative to the website's root into a full URL,
+// e.g. "posts/" => "https://example.com/blog/posts/"
+// Absolute URLs are returned as-is.
+func absURL(site *SiteMetadata, p string) string {
+	if isAbsoluteURL(p) {
+		return p
+	}
+	return strings.TrimSuffix(site.Address, "/") + relURL(site, p)
+}
+
+// Prepends the website's root to a path, e.g. "posts/" => "/blog/posts/"
+// Paths that already start with a "/" are returned as-is.
+func relURL(site *SiteMetadata, p string) string {
+	if isAbsoluteURL(p) || strings.HasPrefix(p, "/") {
+		return p
+	}
+	return site.Root + p
+}
+
+var dateNameRegex = regexp.MustCompile(`January|Jan|Monday|Mon`)
+
+// Month then weekday names, long then short
+var dateNames = map[string][4][]string{
+	"en": {
+		{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+	},
+	"vi": {
+		{"tháng một", "tháng hai", "tháng ba", "tháng tư", "tháng năm", "tháng sáu", "tháng bảy", "tháng tám", "tháng chín", "tháng mười", "tháng mười một", "tháng mười hai"},
+		{"thg 1", "thg 2", "thg 3", "thg 4", "thg 5", "thg 6", "thg 7", "thg 8", "thg 9", "thg 10", "thg 11", "thg 12"},
+		{"Chủ nhật", "Thứ hai", "Thứ ba", "Thứ tư", "Thứ năm", "Thứ sáu", "Thứ bảy"},
+		{"CN", "T2", "T3", "T4", "T5", "T6", "T7"},
+	},
+	"fr": {
+		{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"de": {
+		{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+	},
+	"es": {
+		{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+}
+
+// Like time.Format, but with month and weekday names in the given locale,
+// which defaults to English: formatDate "2 January 2006" .PostedAt "fr"
+func formatDate(layout string, t time.Time, locale ...string) (string, error) {
+	lang := "en"
+	if len(locale) > 0 {
+		lang = locale[0]
+	}
+	names, ok := dateNames[lang]
+	if !ok {
+		supported := make([]string, 0, len(dateNames))
+		for l := range dateNames {
+			supported = append(supported, l)
+		}
+		sort.Strings(supported)
+		return "", fmt.Errorf(
+			`formatDate: unsupported locale "%s", expected one of: %s`,
+			lang, strings.Join(supported, ", "),
+		)
+	}
+
+	// Names are substituted outside of time.Format, because they may
+	// contain something that looks like a layout element, e.g. "thg 1".
+	t = t.Local()
+	var b strings.Builder
+	rest := layout
+	for {
+		loc := dateNameRegex.FindStringIndex(rest)
+		if loc == nil {
+			b.WriteString(t.Format(rest))
+			return b.String(), nil
+		}
+		b.WriteString(t.Format(rest[:loc[0]]))
+		switch rest[loc[0]:loc[1]] {
+		case "January":
+			b.WriteString(names[0][t.Month()-1])
+		case "Jan":
+			b.WriteString(names[1][t.Month()-1])
+		case "Monday":
+			b.WriteString(names[2][t.Weekday()])
+		case "Mon":
+			b.WriteString(names[3][t.Weekday()])
+		}
+		rest = rest[loc[1]:]
+	}
+}
+
+// Shortens s to at most n characters, preferably at a word boundary,
+// adding an ellipsis if anything was cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	cut := string(runes[:n])
+	if i := strings.LastIndexAny(cut, " \t\n"); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " \t\n.,;:") + "…"
+}
+
+// Strips html tags, e.g. to use an article's Summary in a meta tag.
+func plainify(s any) string {
+	var text strings.Builder
+	z := html.NewTokenizer(strings.NewReader(fmt.Sprint(s)))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(text.String())
+		case html.TextToken:
+			text.Write(z.Text())
+		}
+	}
+}
+
+var djotPunctuation = regexp.MustCompile("[\\\\`*_{}\\[\\]()<>#+\\-.!|~^=\"':$%&@]")
+
+// Backslash-escapes djot punctuation so s is rendered literally when used
+// in djot text, e.g. in a generated .dj file.
+func escapeDjot(s string) string {
+	return djotPunctuation.ReplaceAllString(s, `\$0`)
+}
+
+// First n items of a list, or all of them if there are fewer.
+func first(n int, list any) (any, error) {
+	v, err := listValue("first", list)
+	if err != nil {
+		return nil, err
+	}
+	return v.Slice(0, min(max(n, 0), v.Len())).Interface(), nil
+}
+
+// Last n items of a list, or all of them if there are fewer.
+func last(n int, list any) (any, error) {
+	v, err := listValue("last", list)
+	if err != nil {
+		return nil, err
+	}
+	return v.Slice(max(v.Len()-max(n, 0), 0), v.Len()).Interface(), nil
+}
+
+// Items of a list after skipping the first n.
+func after(n int, list any) (any, error) {
+	v, err := listValue("after", list)
+	if err != nil {
+		return nil, err
+	}
+	return v.Slice(min(max(n, 0), v.Len()), v.Len()).Interface(), nil
+}
+
+// Items of a list whose field equals value: where .ArticlesInFeed "PageType" "post"
+// Field values are compared by their string representation, so that
+// template literals can match non-string fields.
+func where(list any, field string, value any) (any, error) {
+	v, err := listValue("where", list)
+	if err != nil {
+		return nil, err
+	}
+	result := reflect.MakeSlice(v.Type(), 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		f, err := fieldValue("where", v.Index(i), field)
+		if err != nil {
+			return nil, err
+		}
+		if fmt.Sprint(f.Interface()) == fmt.Sprint(value) {
+			result = reflect.Append(result, v.Index(i))
+		}
+	}
+	return result.Interface(), nil
+}
+
+// Sorted copy of a list, by a string, number, bool or time field:
+// sortBy .ArticlesInFeed "Title" or sortBy .ArticlesInFeed "WordCount" "desc"
+func sortBy(list any, field string, order ...string) (any, error) {
+	v, err := listValue("sortBy", list)
+	if err != nil {
+		return nil, err
+	}
+	desc := len(order) > 0 && order[0] == "desc"
+	if len(order) > 0 && order[0] != "asc" && order[0] != "desc" {
+		return nil, fmt.Errorf(`sortBy: order must be "asc" or "desc", got "%s"`, order[0])
+	}
+
+	result := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(result, v)
+	keys := make([]reflect.Value, v.Len())
+	for i := range keys {
+		keys[i], err = fieldValue("sortBy", result.Index(i), field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sortErr error
+	indices := make([]int, v.Len())
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		a, b := keys[indices[i]], keys[indices[j]]
+		if desc {
+			a, b = b, a
+		}
+		less, err := lessValue(a, b)
+		if err != nil {
+			sortErr = err
+		}
+		return less
+	})
+	if sortErr != nil {
+		return nil, fmt.Errorf("sortBy: %w", sortErr)
+	}
+
+	sorted := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	for i, idx := range indices {
+		sorted.Index(i).Set(result.Index(idx))
+	}
+	return sorted.Interface(), nil
+}
+
+func lessValue(a, b reflect.Value) (bool, error) {
+	if t, ok := a.Interface().(time.Time); ok {
+		return t.Before(b.Interface().(time.Time)), nil
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return a.String() < b.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int(), nil
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float(), nil
+	case reflect.Bool:
+		return !a.Bool() && b.Bool(), nil
+	}
+	return false, fmt.Errorf("cannot sort by %s values", a.Type())
+}
+
+// Map built from key-value pairs, e.g. to pass several values to a nested
+// template: {{template "card" dict "Post" . "Big" true}}
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict: expected an even number of arguments")
+	}
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+func listValue(funcName string, list any) (reflect.Value, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return v, fmt.Errorf("%s: expected a list, got %T", funcName, list)
+	}
+	if v.Kind() == reflect.Array {
+		s := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+		reflect.Copy(s, v)
+		v = s
+	}
+	return v, nil
+}
+
+// Looks up a struct field (or map key) by name, through pointers.
+func fieldValue(funcName string, item reflect.Value, field string) (reflect.Value, error) {
+	for item.Kind() == reflect.Pointer || item.Kind() == reflect.Interface {
+		if item.IsNil() {
+			return item, fmt.Errorf("%s: nil item in list", funcName)
+		}
+		item = item.Elem()
+	}
+	switch item.Kind() {
+	case reflect.Struct:
+		f := item.FieldByName(field)
+		if !f.IsValid() {
+			return f, fmt.Errorf(`%s: %s has no field "%s"`, funcName, item.Type(), field)
+		}
+		return f, nil
+	case reflect.Map:
+		f := item.MapIndex(reflect.ValueOf(field))
+		if !f.IsValid() {
+			return f, fmt.Errorf(`%s: no key "%s"`, funcName, field)
+		}
+		return f, nil
+	}
+	return item, fmt.Errorf("%s: cannot get field of %s", funcName, item.Type())
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatDate(t *testing.T) {
+	// A Tuesday
+	date := time.Date(2024, time.March, 5, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		layout, locale, want string
+	}{
+		{"2006-01-02", "", "2024-03-05"},
+		{"2 January 2006", "", "5 March 2024"},
+		{"Mon, 2 Jan 2006 15:04", "", "Tue, 5 Mar 2024 14:30"},
+		{"Monday 2 January 2006", "fr", "mardi 5 mars 2024"},
+		{"Mon 2 Jan", "fr", "mar. 5 mars"},
+		{"2. January 2006", "de", "5. März 2024"},
+		{"Monday, 2 de January", "es", "martes, 5 de marzo"},
+		// "thg 3" mustn't be read as a layout, where 3 would be the hour
+		{"2 Jan 2006", "vi", "5 thg 3 2024"},
+		{"Monday, 2 January", "vi", "Thứ ba, 5 tháng ba"},
+		{"January", "vi", "tháng ba"},
+		{"Mon", "vi", "T3"},
+	}
+	for _, tt := range tests {
+		var locale []string
+		if tt.locale != "" {
+			locale = []string{tt.locale}
+		}
+		got, err := formatDate(tt.layout, date, locale...)
+		if err != nil {
+			t.Errorf("formatDate(%q, %q): %s", tt.layout, tt.locale, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("formatDate(%q, %q) = %q, want %q", tt.layout, tt.locale, got, tt.want)
+		}
+	}
+
+	if _, err := formatDate("2006", date, "xx"); err == nil {
+		t.Error("expected an error for unsupported locale")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		n       int
+		s, want string
+	}{
+		{10, "", ""},
+		{10, "short", "short"},
+		{11, "hello world", "hello world"},
+		{10, "hello world", "hello…"},
+		{8, "hello, world", "hello…"},
+		{3, "abcdef", "abc…"},
+		{7, "tiếng việt có dấu", "tiếng…"},
+		{0, "abc", "…"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.n, tt.s); got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.n, tt.s, got, tt.want)
+		}
+	}
+}
+
+type testItem struct {
+	Title    string
+	Words    int
+	Draft    bool
+	PostedAt time.Time
+}
+
+var (
+	itemA = testItem{"a", 30, false, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	itemB = testItem{"b", 10, true, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)}
+	itemC = testItem{"c", 20, false, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+)
+
+func TestListSlicing(t *testing.T) {
+	list := []testItem{itemA, itemB, itemC}
+	tests := []struct {
+		name string
+		fn   func(int, any) (any, error)
+		n    int
+		want []testItem
+	}{
+		{"first", first, 2, []testItem{itemA, itemB}},
+		{"first", first, 5, []testItem{itemA, itemB, itemC}},
+		{"first", first, 0, []testItem{}},
+		{"first", first, -1, []testItem{}},
+		{"last", last, 2, []testItem{itemB, itemC}},
+		{"last", last, 5, []testItem{itemA, itemB, itemC}},
+		{"last", last, 0, []testItem{}},
+		{"after", after, 1, []testItem{itemB, itemC}},
+		{"after", after, 3, []testItem{}},
+		{"after", after, 5, []testItem{}},
+		{"after", after, -1, []testItem{itemA, itemB, itemC}},
+	}
+	for _, tt := range tests {
+		got, err := tt.fn(tt.n, list)
+		if err != nil {
+			t.Errorf("%s(%d): %s", tt.name, tt.n, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s(%d) = %v, want %v", tt.name, tt.n, got, tt.want)
+		}
+	}
+
+	// Arrays work too, and non-lists are errors
+	got, err := first(1, [2]int{1, 2})
+	if err != nil || !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("first(1, array) = %v, %v", got, err)
+	}
+	if _, err := last(1, "abc"); err == nil {
+		t.Error("expected an error for a non-list")
+	}
+}
+
+func TestWhere(t *testing.T) {
+	list := []*testItem{&itemA, &itemB, &itemC}
+	tests := []struct {
+		field string
+		value any
+		want  []*testItem
+	}{
+		{"Title", "b", []*testItem{&itemB}},
+		{"Draft", false, []*testItem{&itemA, &itemC}},
+		// Template literals compare by string representation
+		{"Words", 20, []*testItem{&itemC}},
+		{"Words", "20", []*testItem{&itemC}},
+		{"Title", "nope", []*testItem{}},
+	}
+	for _, tt := range tests {
+		got, err := where(list, tt.field, tt.value)
+		if err != nil {
+			t.Errorf("where(%q, %v): %s", tt.field, tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("where(%q, %v) = %v, want %v", tt.field, tt.value, got, tt.want)
+		}
+	}
+
+	maps := []map[string]any{{"k": "x"}, {"k": "y"}}
+	got, err := where(maps, "k", "y")
+	if err != nil || !reflect.DeepEqual(got, []map[string]any{{"k": "y"}}) {
+		t.Errorf("where(maps) = %v, %v", got, err)
+	}
+
+	if _, err := where(list, "Missing", 1); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if _, err := where([]*testItem{nil}, "Title", ""); err == nil {
+		t.Error("expected an error for a nil item")
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	list := []testItem{itemA, itemB, itemC}
+	tests := []struct {
+		field string
+		order []string
+		want  []testItem
+	}{
+		{"Title", nil, []testItem{itemA, itemB, itemC}},
+		{"Title", []string{"desc"}, []testItem{itemC, itemB, itemA}},
+		{"Words", nil, []testItem{itemB, itemC, itemA}},
+		{"Words", []string{"asc"}, []testItem{itemB, itemC, itemA}},
+		{"Words", []string{"desc"}, []testItem{itemA, itemC, itemB}},
+		{"PostedAt", nil, []testItem{itemB, itemA, itemC}},
+		{"PostedAt", []string{"desc"}, []testItem{itemC, itemA, itemB}},
+		// Stable, so equal keys keep their order either way
+		{"Draft", nil, []testItem{itemA, itemC, itemB}},
+		{"Draft", []string{"desc"}, []testItem{itemB, itemA, itemC}},
+	}
+	for _, tt := range tests {
+		got, err := sortBy(list, tt.field, tt.order...)
+		if err != nil {
+			t.Errorf("sortBy(%q, %v): %s", tt.field, tt.order, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortBy(%q, %v) = %v, want %v", tt.field, tt.order, got, tt.want)
+		}
+	}
+
+	if list[0] != itemA || list[1] != itemB || list[2] != itemC {
+		t.Errorf("sortBy modified its input: %v", list)
+	}
+	if _, err := sortBy(list, "Title", "up"); err == nil {
+		t.Error("expected an error for an invalid order")
+	}
+	if _, err := sortBy([]struct{ S []int }{{}, {}}, "S"); err == nil {
+		t.Error("expected an error for an unsortable field")
+	}
+}
+
+func TestDict(t *testing.T) {
+	tests := []struct {
+		pairs   []any
+		want    map[string]any
+		wantErr bool
+	}{
+		{nil, map[string]any{}, false},
+		{[]any{"Post", 1, "Big", true}, map[string]any{"Post": 1, "Big": true}, false},
+		{[]any{"a", 1, "a", 2}, map[string]any{"a": 2}, false},
+		{[]any{"a"}, nil, true},
+		{[]any{1, "a"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := dict(tt.pairs...)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dict(%v) error = %v, wantErr %v", tt.pairs, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dict(%v) = %v, want %v", tt.pairs, got, tt.want)
+		}
+	}
+}
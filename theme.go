@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+
+	"go.imnhan.com/s4g/writablefs"
+)
+
+// Non-template files of the embedded default theme that the site doesn't
+// override are written here, so pages can still link to them.
+var DefaultThemePath = S4gDir + "/default-theme"
+
+// Site's files, where a missing file in the theme folder falls back to the
+// embedded default theme. This way a site only needs to keep the theme files
+// it customized.
+type themeFS struct {
+	fs.FS
+}
+
+func (t themeFS) Open(name string) (fs.File, error) {
+	f, err := t.FS.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if rel, ok := strings.CutPrefix(name, ThemePath+"/"); ok {
+			if df, derr := defaultTheme.Open("theme/" + rel); derr == nil {
+				return df, nil
+			}
+		}
+	}
+	return f, err
+}
+
+// URL of a theme file, e.g. themeURL "base.css", pointing to the site's own
+// copy if any, or else to the default theme's, which includes files generated
+// into DefaultThemePath such as highlight.css.
+func themeURL(site *SiteMetadata, fsys fs.FS, name string) string {
+	if !fileExists(fsys, ThemePath+"/"+name) &&
+		(isDefaultThemeFile(name) || fileExists(fsys, DefaultThemePath+"/"+name)) {
+		return site.Root + DefaultThemePath + "/" + name
+	}
+	return site.Root + ThemePath + "/" + name
+}
+
+func isDefaultThemeFile(name string) bool {
+	return fileExists(defaultTheme, "theme/"+name)
+}
+
+// Writes default theme assets that the site doesn't override into
+// DefaultThemePath.
+func writeDefaultThemeAssets(fsys writablefs.FS, generatedFiles map[string]bool) error {
+	return fs.WalkDir(defaultTheme, "theme", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(p) == ".tmpl" {
+			return err
+		}
+		rel := strings.TrimPrefix(p, "theme/")
+		if fileExists(fsys, ThemePath+"/"+rel) {
+			return nil
+		}
+
+		content, err := fs.ReadFile(defaultTheme, p)
+		if err != nil {
+			return err
+		}
+		outPath := DefaultThemePath + "/" + rel
+		if err := fsys.MkdirAll(path.Dir(outPath)); err != nil {
+			return err
+		}
+		if err := fsys.WriteFile(outPath, content); err != nil {
+			return err
+		}
+		generatedFiles[outPath] = true
+		return nil
+	})
+}
+
+func isDefaultThemeAsset(p string) bool {
+	return strings.HasPrefix(p, DefaultThemePath+"/")
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestThemeURL(t *testing.T) {
+	site := &SiteMetadata{Root: "/blog/"}
+	tests := []struct {
+		name  string
+		asset string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "built-in asset",
+			asset: "base.css",
+			want:  "/blog/" + DefaultThemePath + "/base.css",
+		},
+		{
+			name:  "overridden asset",
+			asset: "base.css",
+			files: map[string]string{ThemePath + "/base.css": ""},
+			want:  "/blog/" + ThemePath + "/base.css",
+		},
+		{
+			name:  "generated highlight.css",
+			asset: "highlight.css",
+			files: map[string]string{HighlightCSSPath: ""},
+			want:  "/blog/" + HighlightCSSPath,
+		},
+		{
+			name:  "own highlight.css",
+			asset: "highlight.css",
+			files: map[string]string{
+				HighlightCSSPath:             "",
+				ThemePath + "/highlight.css": "",
+			},
+			want: "/blog/" + ThemePath + "/highlight.css",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := newTestFS(t, tt.files)
+			if got := themeURL(site, fsys, tt.asset); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
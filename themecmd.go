@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"go.imnhan.com/s4g/writablefs"
+)
+
+// Pristine copy of the built-in theme that the site's theme was copied from,
+// used as the common ancestor when upgrading.
+var ThemeBasePath = S4gDir + "/.theme-base"
+
+// Built-in theme files, relative to the theme folder.
+var builtinTheme, _ = fs.Sub(defaultTheme, "theme")
+
+const (
+	conflictStartMarker = "<<<<<<< yours"
+	conflictBaseMarker  = "||||||| original"
+	conflictSepMarker   = "======="
+	conflictEndMarker   = ">>>>>>> s4g"
+)
+
+func handleThemeCmd(folder string, upgrade bool) {
+	fsys := openSiteFolder(folder)
+	if upgrade {
+		if conflicts := upgradeTheme(fsys); conflicts > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	diffTheme(fsys)
+}
+
+// Prints how the site's theme files differ from the built-in ones.
+func diffTheme(fsys fs.FS) {
+	numDiffs := 0
+	for _, name := range themeFiles(builtinTheme) {
+		builtin, _ := fs.ReadFile(builtinTheme, name)
+		sitePath := ThemePath + "/" + name
+		site, err := fs.ReadFile(fsys, sitePath)
+		if err != nil {
+			fmt.Printf("Only in built-in theme: %s\n", name)
+			continue
+		}
+		if bytes.Equal(site, builtin) {
+			continue
+		}
+		numDiffs++
+		fmt.Printf("--- %s\n+++ built-in/%s\n", sitePath, name)
+		fmt.Print(unifiedDiff(splitLines(site), splitLines(builtin)))
+	}
+	if numDiffs == 0 {
+		fmt.Println("Theme is identical to the built-in one.")
+	} else {
+		fmt.Printf("%d files differ from the built-in theme.\n", numDiffs)
+	}
+}
+
+// Brings the built-in theme's changes since the site was made into the
+// site's theme, keeping the user's own changes. Returns the number of
+// conflicts left for the user to resolve.
+func upgradeTheme(fsys writablefs.FS) (conflicts int) {
+	names := themeFiles(builtinTheme)
+	for _, name := range themeFiles(mustSub(fsys, ThemeBasePath)) {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	numChanged, numSkipped := 0, 0
+	for _, name := range names {
+		sitePath := ThemePath + "/" + name
+		basePath := ThemeBasePath + "/" + name
+		site, siteErr := fs.ReadFile(fsys, sitePath)
+		base, baseErr := fs.ReadFile(fsys, basePath)
+		builtin, builtinErr := fs.ReadFile(builtinTheme, name)
+
+		switch {
+		case builtinErr != nil:
+			// Dropped from the built-in theme
+			if siteErr == nil && baseErr == nil && bytes.Equal(site, base) {
+				fsys.RemoveAll(sitePath)
+				fmt.Println("Removed", sitePath)
+				numChanged++
+			} else if siteErr == nil {
+				fmt.Printf("WARN: %s is no longer part of the built-in theme, kept as-is\n", sitePath)
+			}
+			fsys.RemoveAll(basePath)
+			continue
+
+		case siteErr != nil:
+			// Not overridden, so the built-in version is already used
+
+		case bytes.Equal(site, builtin):
+
+		case baseErr != nil:
+			fmt.Printf(
+				"WARN: %s: no original copy recorded, so it can't be upgraded automatically."+
+					" Compare it using `s4g theme`, or delete it to use the built-in version.\n",
+				sitePath,
+			)
+			numSkipped++
+			continue
+
+		case bytes.Equal(base, builtin):
+			// Customized, but the built-in version hasn't changed since
+
+		case bytes.Equal(site, base):
+			fsys.WriteFile(sitePath, builtin)
+			fmt.Println("Updated", sitePath)
+			numChanged++
+
+		default:
+			merged, n := merge3(splitLines(base), splitLines(site), splitLines(builtin))
+			fsys.WriteFile(sitePath, []byte(strings.Join(merged, "")))
+			numChanged++
+			if n > 0 {
+				fmt.Printf("CONFLICT %s: %d conflicts, resolve them between the %s and %s markers\n",
+					sitePath, n, conflictStartMarker, conflictEndMarker)
+				conflicts += n
+			} else {
+				fmt.Println("Merged", sitePath)
+			}
+		}
+
+		fsys.MkdirAll(path.Dir(basePath))
+		fsys.WriteFile(basePath, builtin)
+	}
+
+	if numChanged == 0 && numSkipped == 0 {
+		fmt.Println("Theme is already up to date.")
+	}
+	return conflicts
+}
+
+// Paths of all files in fsys, sorted.
+func themeFiles(fsys fs.FS) []string {
+	var names []string
+	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, p)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Splits content into lines, keeping their line endings.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines a[aStart:aEnd] are replaced with b[bStart:bEnd].
+type hunk struct {
+	aStart, aEnd, bStart, bEnd int
+}
+
+// Finds the smallest set of changes that turn a into b, using the longest
+// common subsequence of their lines. Theme files are small enough for the
+// quadratic table.
+func diffHunks(a, b []string) []hunk {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var hunks []hunk
+	var current *hunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			i++
+			j++
+			continue
+		}
+		if current == nil {
+			current = &hunk{aStart: i, aEnd: i, bStart: j, bEnd: j}
+		}
+		if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+			current.aEnd = i
+		} else {
+			j++
+			current.bEnd = j
+		}
+	}
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+// Renders the changes from a to b in unified diff format, minus the file
+// headers.
+func unifiedDiff(a, b []string) string {
+	const context = 3
+	hunks := diffHunks(a, b)
+	var out strings.Builder
+	for len(hunks) > 0 {
+		// Changes close enough to share their context go in the same block
+		n := 1
+		for n < len(hunks) && hunks[n].aStart-hunks[n-1].aEnd <= 2*context {
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		aStart := max(group[0].aStart-context, 0)
+		bStart := group[0].bStart - (group[0].aStart - aStart)
+		aEnd := min(group[n-1].aEnd+context, len(a))
+		bEnd := group[n-1].bEnd + (aEnd - group[n-1].aEnd)
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			diffRange(aStart, aEnd), diffRange(bStart, bEnd))
+		pos := aStart
+		for _, h := range group {
+			writeDiffLines(&out, " ", a[pos:h.aStart])
+			writeDiffLines(&out, "-", a[h.aStart:h.aEnd])
+			writeDiffLines(&out, "+", b[h.bStart:h.bEnd])
+			pos = h.aEnd
+		}
+		writeDiffLines(&out, " ", a[pos:aEnd])
+	}
+	return out.String()
+}
+
+func diffRange(start, end int) string {
+	if start == end {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func writeDiffLines(out *strings.Builder, prefix string, lines []string) {
+	for _, l := range lines {
+		out.WriteString(prefix + l)
+		if !strings.HasSuffix(l, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Three-way merge of lines: changes made to base in either ours or theirs are
+// kept. Where both changed the same lines differently, both versions are
+// kept between conflict markers.
+func merge3(base, ours, theirs []string) (merged []string, conflicts int) {
+	oursHunks := diffHunks(base, ours)
+	theirsHunks := diffHunks(base, theirs)
+
+	pos := 0
+	for len(oursHunks) > 0 || len(theirsHunks) > 0 {
+		// Start with the earliest change, then pull in every change from
+		// either side that overlaps or touches the region so far.
+		var lo, hi int
+		if len(theirsHunks) == 0 ||
+			(len(oursHunks) > 0 && oursHunks[0].aStart <= theirsHunks[0].aStart) {
+			lo, hi = oursHunks[0].aStart, oursHunks[0].aEnd
+		} else {
+			lo, hi = theirsHunks[0].aStart, theirsHunks[0].aEnd
+		}
+		numOurs, numTheirs := 0, 0
+		for {
+			if numOurs < len(oursHunks) && oursHunks[numOurs].aStart <= hi {
+				hi = max(hi, oursHunks[numOurs].aEnd)
+				numOurs++
+			} else if numTheirs < len(theirsHunks) && theirsHunks[numTheirs].aStart <= hi {
+				hi = max(hi, theirsHunks[numTheirs].aEnd)
+				numTheirs++
+			} else {
+				break
+			}
+		}
+
+		merged = append(merged, base[pos:lo]...)
+		oursPart := applyHunks(base, ours, oursHunks[:numOurs], lo, hi)
+		theirsPart := applyHunks(base, theirs, theirsHunks[:numTheirs], lo, hi)
+		switch {
+		case numTheirs == 0:
+			merged = append(merged, oursPart...)
+		case numOurs == 0 || slices.Equal(oursPart, theirsPart):
+			merged = append(merged, theirsPart...)
+		default:
+			conflicts++
+			merged = appendConflictLine(merged, conflictStartMarker)
+			merged = append(merged, oursPart...)
+			merged = appendConflictLine(merged, conflictBaseMarker)
+			merged = append(merged, base[lo:hi]...)
+			merged = appendConflictLine(merged, conflictSepMarker)
+			merged = append(merged, theirsPart...)
+			merged = appendConflictLine(merged, conflictEndMarker)
+		}
+
+		oursHunks = oursHunks[numOurs:]
+		theirsHunks = theirsHunks[numTheirs:]
+		pos = hi
+	}
+	merged = append(merged, base[pos:]...)
+	return merged, conflicts
+}
+
+// Returns base[lo:hi] with hunks, which must all fall within it, applied.
+func applyHunks(base, changed []string, hunks []hunk, lo, hi int) []string {
+	var result []string
+	pos := lo
+	for _, h := range hunks {
+		result = append(result, base[pos:h.aStart]...)
+		result = append(result, changed[h.bStart:h.bEnd]...)
+		pos = h.aEnd
+	}
+	return append(result, base[pos:hi]...)
+}
+
+func appendConflictLine(lines []string, marker string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+	return append(lines, marker+"\n")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name:   "unchanged",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:   "only theirs changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "only ours changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\nd\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\nd\n",
+		},
+		{
+			name:   "non-overlapping edits",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "a\nB\nc\nd\ne\n",
+			theirs: "a\nb\nc\nD\ne\n",
+			want:   "a\nB\nc\nD\ne\n",
+		},
+		{
+			name:   "non-overlapping insert and delete",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "x\na\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\ne\n",
+			want:   "x\na\nb\nc\ne\n",
+		},
+		{
+			name:   "same edit on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "conflicting edit",
+			base:   "a\nb\nc\n",
+			ours:   "a\nmine\nc\n",
+			theirs: "a\nnew\nc\n",
+			want: "a\n<<<<<<< yours\nmine\n||||||| original\nb\n" +
+				"=======\nnew\n>>>>>>> s4g\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:   "both insert at same point",
+			base:   "a\nb\n",
+			ours:   "a\nx\nb\n",
+			theirs: "a\ny\nb\n",
+			want: "a\n<<<<<<< yours\nx\n||||||| original\n" +
+				"=======\ny\n>>>>>>> s4g\nb\n",
+			conflicts: 1,
+		},
+		{
+			name:   "ours deletes line that theirs edited",
+			base:   "a\nb\nc\n",
+			ours:   "a\nc\n",
+			theirs: "a\nB\nc\n",
+			want: "a\n<<<<<<< yours\n||||||| original\nb\n" +
+				"=======\nB\n>>>>>>> s4g\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:   "theirs deletes line that ours edited",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nc\n",
+			want: "a\n<<<<<<< yours\nB\n||||||| original\nb\n" +
+				"=======\n>>>>>>> s4g\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:   "conflict plus clean edit",
+			base:   "a\nb\nc\nd\ne\nf\n",
+			ours:   "A\nb\nc\nd\nmine\nf\n",
+			theirs: "a\nb\nc\nd\nnew\nf\n",
+			want: "A\nb\nc\nd\n<<<<<<< yours\nmine\n||||||| original\ne\n" +
+				"=======\nnew\n>>>>>>> s4g\nf\n",
+			conflicts: 1,
+		},
+		{
+			name:   "missing final newline",
+			base:   "a\nb",
+			ours:   "a\nmine",
+			theirs: "a\nnew",
+			want: "a\n<<<<<<< yours\nmine\n||||||| original\nb\n" +
+				"=======\nnew\n>>>>>>> s4g\n",
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, conflicts := merge3(
+				splitLines([]byte(tt.base)),
+				splitLines([]byte(tt.ours)),
+				splitLines([]byte(tt.theirs)),
+			)
+			if got := strings.Join(merged, ""); got != tt.want {
+				t.Errorf("merged:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if conflicts != tt.conflicts {
+				t.Errorf("conflicts = %d, want %d", conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []hunk
+	}{
+		{"identical", "a\nb\n", "a\nb\n", nil},
+		{"both empty", "", "", nil},
+		{"insert at start", "b\n", "a\nb\n", []hunk{{0, 0, 0, 1}}},
+		{"delete at end", "a\nb\n", "a\n", []hunk{{1, 2, 1, 1}}},
+		{"replace middle", "a\nb\nc\n", "a\nB\nc\n", []hunk{{1, 2, 1, 2}}},
+		{
+			"separate changes", "a\nb\nc\nd\n", "A\nb\nc\nD\n",
+			[]hunk{{0, 1, 0, 1}, {3, 4, 3, 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := splitLines([]byte(tt.a)), splitLines([]byte(tt.b))
+			got := diffHunks(a, b)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+			// Applying the hunks to a must give back b
+			patched := applyHunks(a, b, got, 0, len(a))
+			if strings.Join(patched, "") != tt.b {
+				t.Errorf("patched = %q, want %q", strings.Join(patched, ""), tt.b)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := splitLines([]byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"))
+	b := splitLines([]byte("1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"))
+	want := "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+		"@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n"
+	if got := unifiedDiff(a, b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -20,3 +23,18 @@ func contains(s []string, e string) bool {
 	}
 	return false
 }
+
+// Escapes each segment of a slash-separated path so it can be used in URLs.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	escaped := make([]string, len(parts))
+	for i := 0; i < len(parts); i++ {
+		escaped[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(escaped, "/")
+}
+
+func fileExists(fsys fs.FS, path string) bool {
+	stat, err := fs.Stat(fsys, path)
+	return err == nil && !stat.IsDir()
+}
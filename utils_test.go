@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.imnhan.com/s4g/writablefs"
+)
+
+// Website folder in a temp dir, populated with files: path => content.
+func newTestFS(t *testing.T, files map[string]string) writablefs.FS {
+	t.Helper()
+	dir := t.TempDir()
+	for p, content := range files {
+		fullPath := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return writablefs.WriteDirFS(dir)
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := map[string]string{
+		"posts/hello.html":      "posts/hello.html",
+		"my posts/a b.html":     "my%20posts/a%20b.html",
+		"tiếng việt/index.html": "ti%E1%BA%BFng%20vi%E1%BB%87t/index.html",
+		"":                      "",
+	}
+	for in, want := range tests {
+		if got := escapePath(in); got != want {
+			t.Errorf("escapePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
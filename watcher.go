@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -25,6 +26,11 @@ func WatchLocalFS(fsys writablefs.FS, callback func()) (Close func() error) {
 
 	fsysPath := fsys.Path()
 
+	watchedDeps.mut.Lock()
+	watchedDeps.watcher = watcher
+	watchedDeps.fsysPath = fsysPath
+	watchedDeps.mut.Unlock()
+
 	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if !d.IsDir() || (shouldIgnore(path) && path != ".") {
 			return nil
@@ -52,19 +58,23 @@ func WatchLocalFS(fsys writablefs.FS, callback func()) (Close func() error) {
 					return
 				}
 
-				if shouldIgnore(event.Name) {
-					break
-				}
-
 				relPath, err := filepath.Rel(fsysPath, event.Name)
 				if err != nil {
 					panic(err)
 				}
 
-				// Avoid infinite loop
-				if filepath.Ext(relPath) == ".html" ||
-					relPath == FeedPath ||
-					relPath == ManifestPath {
+				// Avoid infinite loop, even if a generated file is included
+				// by an article.
+				if isGeneratedFile(relPath) {
+					break
+				}
+
+				if isWatchedDep(relPath) {
+					events <- struct{}{}
+					break
+				}
+
+				if shouldIgnore(event.Name) {
 					break
 				}
 
@@ -106,6 +116,72 @@ func WatchLocalFS(fsys writablefs.FS, callback func()) (Close func() error) {
 	return watcher.Close
 }
 
+// Whether relPath is written by regenerate(), so changes to it must not
+// trigger another regeneration.
+func isGeneratedFile(relPath string) bool {
+	return filepath.Ext(relPath) == ".html" ||
+		relPath == FeedPath ||
+		relPath == LinkGraphPath ||
+		relPath == HighlightCSSPath ||
+		isDefaultThemeAsset(relPath) ||
+		isImageVariant(relPath) ||
+		isOGCard(relPath) ||
+		relPath == ManifestPath ||
+		isRedirectConfig(relPath)
+}
+
+// Files that articles depend on, e.g. via includes. Changes to them always
+// trigger regeneration, even if they'd otherwise be ignored.
+var watchedDeps = struct {
+	paths map[string]bool
+	// Folders that were only added to the watcher for dependencies
+	dirs     map[string]bool
+	watcher  *fsnotify.Watcher
+	fsysPath string
+	mut      sync.Mutex
+}{}
+
+func setWatchedDeps(paths []string) {
+	m := make(map[string]bool)
+	for _, p := range paths {
+		m[filepath.FromSlash(p)] = true
+	}
+
+	watchedDeps.mut.Lock()
+	defer watchedDeps.mut.Unlock()
+	watchedDeps.paths = m
+	if watchedDeps.watcher == nil {
+		return
+	}
+	// Dependencies may live in folders that aren't watched, e.g. dot folders
+	watched := make(map[string]bool)
+	for _, dir := range watchedDeps.watcher.WatchList() {
+		watched[dir] = true
+	}
+	dirs := make(map[string]bool)
+	for p := range m {
+		dir := filepath.Join(watchedDeps.fsysPath, filepath.Dir(p))
+		if watchedDeps.dirs[dir] || !watched[dir] {
+			dirs[dir] = true
+		}
+		if !watched[dir] {
+			watchedDeps.watcher.Add(dir)
+		}
+	}
+	for dir := range watchedDeps.dirs {
+		if !dirs[dir] {
+			watchedDeps.watcher.Remove(dir)
+		}
+	}
+	watchedDeps.dirs = dirs
+}
+
+func isWatchedDep(relPath string) bool {
+	watchedDeps.mut.Lock()
+	defer watchedDeps.mut.Unlock()
+	return watchedDeps.paths[relPath]
+}
+
 func printWatchList(w *fsnotify.Watcher) {
 	fmt.Println("WatchList:")
 	for _, path := range w.WatchList() {
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whose text isn't meant to be read: scripts, styles, and the TeX
+// source that MathML output keeps in an annotation.
+var uncountedElements = map[string]bool{
+	"script":     true,
+	"style":      true,
+	"annotation": true,
+}
+
+// Counts words in rendered html. Chinese and Japanese don't separate words
+// with spaces, so each of their characters counts as a word instead.
+// Text in script, style and MathML annotation elements is ignored.
+func countWords(content string) int {
+	count := 0
+	skipping := 0
+	z := html.NewTokenizer(strings.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return count
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if uncountedElements[string(name)] {
+				skipping++
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if uncountedElements[string(name)] && skipping > 0 {
+				skipping--
+			}
+		case html.TextToken:
+			if skipping == 0 {
+				count += countTextWords(string(z.Text()))
+			}
+		}
+	}
+}
+
+func countTextWords(text string) int {
+	count := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case isUnspacedScript(r):
+			count++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				count++
+				inWord = true
+			}
+		// Keep contractions and hyphenated words in one piece
+		case inWord && (r == '\'' || r == '’' || r == '-'):
+		default:
+			inWord = false
+		}
+	}
+	return count
+}
+
+// Korean is left out because it does use spaces between words.
+func isUnspacedScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// Estimated reading time in minutes, rounded up, at least 1 minute for any
+// non-empty text.
+func readingTime(wordCount int, wordsPerMinute int) int {
+	if wordCount == 0 || wordsPerMinute <= 0 {
+		return 0
+	}
+	return (wordCount + wordsPerMinute - 1) / wordsPerMinute
+}
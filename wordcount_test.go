@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestCountWords(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"", 0},
+		{"<p>Hello, world!</p>", 2},
+		{"<p>It's a well-known fact — 42 times.</p>", 6},
+		{"<p>Café <em>naïve</em> résumé</p>", 3},
+		{"<h1>Title</h1><p>One <a href=\"x\">two</a> three</p>", 4},
+		{"<p>日本語のテキスト</p>", 8},
+		{"<p>中文 and English</p>", 4},
+		{"<p>한국어 문장</p>", 2},
+		{"<p>Code:</p><script>var x = 1;</script><style>p { color: red }</style>", 1},
+		{
+			`<p>Area <math><semantics><msup><mi>x</mi><mn>2</mn></msup>` +
+				`<annotation encoding="application/x-tex">x^2 + \frac{a}{b}</annotation>` +
+				`</semantics></math> units</p>`,
+			4,
+		},
+	}
+	for _, tt := range tests {
+		if got := countWords(tt.content); got != tt.want {
+			t.Errorf("countWords(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestReadingTime(t *testing.T) {
+	tests := []struct {
+		words, wpm, want int
+	}{
+		{0, 200, 0},
+		{1, 200, 1},
+		{200, 200, 1},
+		{201, 200, 2},
+		{1000, 250, 4},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := readingTime(tt.words, tt.wpm); got != tt.want {
+			t.Errorf("readingTime(%d, %d) = %d, want %d", tt.words, tt.wpm, got, tt.want)
+		}
+	}
+}